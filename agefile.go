@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/google/uuid"
+)
+
+// Recipients of an export: X25519 public keys separated by commas or spaces,
+// or a passphrase, age does not allow mixing the two
+func ageRecipients(publicKeys, passphrase string) ([]age.Recipient, error) {
+	publicKeys = strings.TrimSpace(publicKeys)
+	if (publicKeys == "") == (passphrase == "") {
+		return nil, fmt.Errorf("give either recipients or a passphrase")
+	}
+
+	if passphrase != "" {
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{recipient}, nil
+	}
+
+	var recipients []age.Recipient
+	for _, publicKey := range strings.FieldsFunc(publicKeys, func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		recipient, err := age.ParseX25519Recipient(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %v", publicKey, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// Identities for an import: an age identity file or a passphrase
+func ageIdentities(identityFile, passphrase string) ([]age.Identity, error) {
+	identityFile = strings.TrimSpace(identityFile)
+	if (identityFile == "") == (passphrase == "") {
+		return nil, fmt.Errorf("give either an identity file or a passphrase")
+	}
+
+	if passphrase != "" {
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Identity{identity}, nil
+	}
+
+	file, err := os.Open(expandHome(identityFile))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return age.ParseIdentities(file)
+}
+
+// Writes the decrypted database as age encrypted JSON
+func ExportPasswordFile(filename string, key []byte, out string, recipients []age.Recipient) (int, error) {
+	_, db, err := openPasswordFile(filename, key)
+	if err != nil {
+		return 0, err
+	}
+
+	// Keys of older backups stay with the vault
+	db.PreviousKeys = nil
+	plaintext, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	defer wipeBytes(plaintext)
+
+	var encrypted bytes.Buffer
+	writer, err := age.Encrypt(&encrypted, recipients...)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := writer.Write(plaintext); err != nil {
+		return 0, err
+	}
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+
+	if err := writeFileExclusive(expandHome(out), encrypted.Bytes(), 0600); err != nil {
+		return 0, err
+	}
+	return len(db.Entries), nil
+}
+
+// Reads a database exported by ExportPasswordFile
+func ReadAgeExport(path string, identities []age.Identity) (*Database, error) {
+	file, err := os.Open(expandHome(path))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := age.Decrypt(file, identities...)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(plaintext)
+
+	// Other JSON, a share bundle for one, decodes to an empty Database without complaint
+	var fields struct {
+		Meta    json.RawMessage `json:"meta"`
+		Entries json.RawMessage `json:"entries"`
+	}
+	if err := json.Unmarshal(plaintext, &fields); err != nil {
+		return nil, fmt.Errorf("not a vault export: %v", err)
+	}
+	if fields.Meta == nil || fields.Entries == nil {
+		return nil, fmt.Errorf("not a vault export: no meta or entries")
+	}
+
+	var db Database
+	if err := json.Unmarshal(plaintext, &db); err != nil {
+		return nil, fmt.Errorf("not a vault export: %v", err)
+	}
+	return &db, nil
+}
+
+// Adds imported entries to a vault, see mergeEntries
+func ImportEntries(path string, key []byte, entries []Entry) (added, skipped int, err error) {
+	passwordFile, db, err := openPasswordFile(path, key)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	added, skipped = mergeEntries(db, entries)
+	if added == 0 {
+		return 0, skipped, nil
+	}
+
+	if err := saveVault(path, passwordFile, db, key); err != nil {
+		return 0, 0, err
+	}
+	return added, skipped, nil
+}
+
+// Entries already present by ID are skipped, titles that are taken get a numbered suffix
+// and histories are cut to the vault history depth
+func mergeEntries(db *Database, entries []Entry) (added, skipped int) {
+	ids := make(map[string]bool)
+	titles := make(map[string]bool)
+	for _, e := range db.Entries {
+		ids[e.ID] = true
+		titles[e.Title] = true
+	}
+
+	depth := db.Meta.historyDepth()
+	for _, entry := range entries {
+		if entry.ID != "" && ids[entry.ID] {
+			skipped++
+			continue
+		}
+		if entry.ID == "" {
+			entry.ID = uuid.NewString()
+		}
+
+		title := entry.Title
+		for n := 2; titles[title]; n++ {
+			title = fmt.Sprintf("%s (%d)", entry.Title, n)
+		}
+		entry.Title = title
+
+		if len(entry.History) > depth {
+			entry.History = entry.History[:depth]
+		}
+
+		ids[entry.ID] = true
+		titles[entry.Title] = true
+		db.Entries = append(db.Entries, entry)
+		added++
+	}
+	return added, skipped
+}
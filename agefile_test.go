@@ -0,0 +1,102 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestReadAgeExport(t *testing.T) {
+	path, key := newTestVault(t)
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients := []age.Recipient{identity.Recipient()}
+	dir := t.TempDir()
+
+	export := filepath.Join(dir, "vault.age")
+	if _, err := ExportPasswordFile(path, key, export, recipients); err != nil {
+		t.Fatal(err)
+	}
+	db, err := ReadAgeExport(export, []age.Identity{identity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Entries) != 1 || db.Entries[0].Title != "GitHub" {
+		t.Errorf("unexpected entries %+v", db.Entries)
+	}
+	if db.PreviousKeys != nil {
+		t.Error("the export carries the previous keys of the vault")
+	}
+
+	// A bundle of one record is age encrypted JSON too, but not a vault
+	bundle := filepath.Join(dir, "entry.age")
+	if err := WriteEntryBundle(Entry{Title: "Mail", Password: "secret"}, bundle, recipients); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadAgeExport(bundle, []age.Identity{identity}); err == nil {
+		t.Error("read an entry bundle as a vault export")
+	}
+}
+
+func TestMergeEntries(t *testing.T) {
+	depth := 1
+	history := []EntryVersion{{Password: "old 1"}, {Password: "old 2"}}
+
+	tests := []struct {
+		name    string
+		entries []Entry
+		added   int
+		skipped int
+		// Titles of the vault after the merge
+		titles []string
+	}{
+		{"new record", []Entry{{ID: "2", Title: "Mail"}}, 1, 0, []string{"GitHub", "Mail"}},
+		{"same ID", []Entry{{ID: "1", Title: "GitHub"}, {ID: "1", Title: "Renamed"}}, 0, 2, []string{"GitHub"}},
+		{"title collision", []Entry{{ID: "2", Title: "GitHub"}, {ID: "3", Title: "GitHub"}}, 2, 0,
+			[]string{"GitHub", "GitHub (2)", "GitHub (3)"}},
+		{"suffixed title taken", []Entry{{ID: "2", Title: "GitHub (2)"}, {ID: "3", Title: "GitHub"}}, 2, 0,
+			[]string{"GitHub", "GitHub (2)", "GitHub (3)"}},
+		{"duplicate within the import", []Entry{{ID: "2", Title: "Mail"}, {ID: "2", Title: "Mail"}}, 1, 1,
+			[]string{"GitHub", "Mail"}},
+		{"no ID", []Entry{{Title: "Mail"}, {Title: "Mail"}}, 2, 0, []string{"GitHub", "Mail", "Mail (2)"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &Database{
+				Meta:    Meta{HistoryDepth: &depth},
+				Entries: []Entry{{ID: "1", Title: "GitHub"}},
+			}
+			entries := make([]Entry, len(tt.entries))
+			for i, entry := range tt.entries {
+				entry.History = history
+				entries[i] = entry
+			}
+
+			added, skipped := mergeEntries(db, entries)
+			if added != tt.added || skipped != tt.skipped {
+				t.Errorf("added %d, skipped %d, want %d and %d", added, skipped, tt.added, tt.skipped)
+			}
+
+			var titles []string
+			ids := make(map[string]bool)
+			for i, entry := range db.Entries {
+				titles = append(titles, entry.Title)
+				if entry.ID == "" || ids[entry.ID] {
+					t.Errorf("record %d has the ID %q", i, entry.ID)
+				}
+				ids[entry.ID] = true
+				if i > 0 && len(entry.History) != depth {
+					t.Errorf("record %d keeps %d versions, want %d", i, len(entry.History), depth)
+				}
+			}
+			if !reflect.DeepEqual(titles, tt.titles) {
+				t.Errorf("titles %q, want %q", titles, tt.titles)
+			}
+		})
+	}
+}
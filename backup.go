@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Timestamps in backup names sort in the order the backups were taken
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// Where backups go and how many are kept per vault, 0 disables them
+type BackupPolicy struct {
+	Dir   string
+	Count int
+}
+
+var vaultBackups BackupPolicy
+
+func SetBackupPolicy(dir string, count int) {
+	vaultBackups = BackupPolicy{Dir: dir, Count: count}
+}
+
+// A copy of a vault file as it was before one save
+type Backup struct {
+	Path string
+	Time time.Time
+}
+
+// Backups are named <vault file>.<UTC time>.bak
+func backupPrefix(path string) string {
+	return filepath.Base(path) + "."
+}
+
+// Copies the vault file as it is now and drops the oldest backups over the limit.
+// Vault files are encrypted, so are the copies
+func backupVault(path string) error {
+	if vaultBackups.Count <= 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(vaultBackups.Dir, 0700); err != nil {
+		return err
+	}
+
+	name := backupPrefix(path) + time.Now().UTC().Format(backupTimeFormat) + ".bak"
+	if err := writeFileAtomic(filepath.Join(vaultBackups.Dir, name), data, 0600); err != nil {
+		return err
+	}
+
+	backups, err := ListBackups(path)
+	if err != nil {
+		return err
+	}
+	for _, backup := range backups[min(vaultBackups.Count, len(backups)):] {
+		if err := os.Remove(backup.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Backups of the vault at path, newest first
+func ListBackups(path string) ([]Backup, error) {
+	files, err := os.ReadDir(vaultBackups.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := backupPrefix(path)
+	var backups []Backup
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".bak") {
+			continue
+		}
+
+		taken, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".bak"))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Path: filepath.Join(vaultBackups.Dir, name), Time: taken})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// Opens a backup with the vault key, or with a key the vault had before a re-key
+func openBackup(path string, backup Backup, key []byte) (*Database, error) {
+	_, db, err := openPasswordFile(backup.Path, key)
+	if err == nil {
+		return db, nil
+	}
+
+	_, current, currentErr := openPasswordFile(path, key)
+	if currentErr != nil {
+		return nil, err
+	}
+	for _, previous := range current.PreviousKeys {
+		oldKey, decodeErr := hex.DecodeString(previous)
+		if decodeErr != nil {
+			continue
+		}
+		_, db, openErr := openPasswordFile(backup.Path, oldKey)
+		wipeBytes(oldKey)
+		if openErr == nil {
+			return db, nil
+		}
+	}
+	return nil, err
+}
+
+// Number of records in a backup of the vault at path
+func BackupEntryCount(path string, backup Backup, key []byte) (int, error) {
+	db, err := openBackup(path, backup, key)
+	if err != nil {
+		return 0, err
+	}
+	return len(db.Entries), nil
+}
+
+// Puts the records of a backup back into the vault. Key slots and previous keys stay as they are now,
+// so a backup from before a member was removed does not give them access again, and the save itself is backed up
+func RestoreBackup(path string, backup Backup, key []byte) (int, error) {
+	backupDB, err := openBackup(path, backup, key)
+	if err != nil {
+		return 0, fmt.Errorf("backup does not open with the vault keys: %v", err)
+	}
+
+	err = updateVault(path, key, func(_ *PasswordFile, db *Database) error {
+		previousKeys := db.PreviousKeys
+		recoveryShares := db.Meta.RecoveryShares
+		*db = *backupDB
+		db.PreviousKeys = previousKeys
+		db.Meta.RecoveryShares = recoveryShares
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(backupDB.Entries), nil
+}
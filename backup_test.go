@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupRotation(t *testing.T) {
+	tests := []struct {
+		count int
+		saves int
+		want  int
+	}{
+		{count: 0, saves: 3, want: 0},
+		{count: 1, saves: 3, want: 1},
+		{count: 3, saves: 2, want: 2},
+		{count: 3, saves: 6, want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("keep %d of %d", tt.count, tt.saves), func(t *testing.T) {
+			path, key := newTestVault(t)
+			SetBackupPolicy(filepath.Join(t.TempDir(), "backups"), tt.count)
+			t.Cleanup(func() { SetBackupPolicy("", 0) })
+
+			for i := 0; i < tt.saves; i++ {
+				if err := AddToPasswordFile(path, Entry{Title: fmt.Sprintf("record %d", i)}, key); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			backups, err := ListBackups(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backups) != tt.want {
+				t.Fatalf("%d backups, want %d", len(backups), tt.want)
+			}
+
+			// Newest first, each one the vault before one of the last saves
+			for i, backup := range backups {
+				if i > 0 && !backup.Time.Before(backups[i-1].Time) {
+					t.Errorf("backup %d is not older than the one before it", i)
+				}
+
+				count, err := BackupEntryCount(path, backup, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := tt.saves - i; count != want {
+					t.Errorf("backup %d has %d records, want %d", i, count, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	path, key := newTestVault(t)
+	SetBackupPolicy(filepath.Join(t.TempDir(), "backups"), defaultBackupCount)
+	t.Cleanup(func() { SetBackupPolicy("", 0) })
+
+	if err := AddToPasswordFile(path, Entry{Title: "Mail"}, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveFromPasswordFile(path, 0, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPasswordFile(path, key); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := ListBackups(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("%d backups, want 2", len(backups))
+	}
+
+	// The newest backup is from before the record was removed
+	restored, err := RestoreBackup(path, backups[0], key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != 2 {
+		t.Errorf("restored %d records, want 2", restored)
+	}
+
+	entries, err := ReadPasswordFile(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Title != "GitHub" || entries[1].Title != "Mail" {
+		t.Errorf("unexpected entries after restore %+v", entries)
+	}
+
+	// The restore is a save of its own, so the state it replaced is backed up too
+	after, err := ListBackups(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 3 {
+		t.Errorf("%d backups after the restore, want 3", len(after))
+	}
+
+	// The vault keeps its old keys, backups from before a re-key still restore
+	header, err := ReadPasswordFileHeader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdf, err := recoveryKDFParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ChangeMasterPassword(path, key, header.Slots[0].ID, "changed", "", kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err = RestoreBackup(path, after[0], result.Key)
+	if err != nil {
+		t.Fatalf("restore of a backup from before the re-key: %v", err)
+	}
+	if restored != 1 {
+		t.Errorf("restored %d records, want 1", restored)
+	}
+	if _, err := ReadPasswordFile(path, result.Key); err != nil {
+		t.Fatal(err)
+	}
+	if isOk, _, _, err := UnlockPasswordFile(path, "changed", ""); err != nil || !isOk {
+		t.Errorf("unlock with the new password after the restore: ok %v, %v", isOk, err)
+	}
+}
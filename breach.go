@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Longest line of the HIBP dump is the 40 char hash, a colon, the count and CRLF
+const breachLineChunk = 128
+
+// Offline lookups in the HIBP Pwned Passwords "SHA-1 ordered by hash" file,
+// one HASH:COUNT line per password sorted by hash
+type BreachFile struct {
+	file *os.File
+	size int64
+}
+
+func OpenBreachFile(path string) (*BreachFile, error) {
+	if path == "" {
+		return nil, fmt.Errorf("breach_file is not set in the config")
+	}
+
+	file, err := os.Open(expandHome(path))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &BreachFile{file: file, size: info.Size()}, nil
+}
+
+func (b *BreachFile) Close() error {
+	return b.file.Close()
+}
+
+// First line starting at or after offset, start is the file size when there is none
+func (b *BreachFile) lineAt(offset int64) (start int64, line string, err error) {
+	from := offset
+	if offset > 0 {
+		// A line starts at offset only if the byte before it ends the previous one
+		from = offset - 1
+	}
+
+	chunk := make([]byte, 2*breachLineChunk)
+	n, err := b.file.ReadAt(chunk, from)
+	if err != nil && err != io.EOF {
+		return 0, "", err
+	}
+	chunk = chunk[:n]
+
+	start = from
+	if offset > 0 {
+		newline := bytes.IndexByte(chunk, '\n')
+		if newline == -1 {
+			return b.size, "", nil
+		}
+		chunk = chunk[newline+1:]
+		start = from + int64(newline) + 1
+	}
+
+	if end := bytes.IndexByte(chunk, '\n'); end != -1 {
+		chunk = chunk[:end+1]
+	}
+	return start, string(chunk), nil
+}
+
+// How often the password appears in the dump, 0 if it does not
+func (b *BreachFile) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	target := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	// Binary search over byte offsets for the first line with hash >= target
+	lo, hi := int64(0), b.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		start, line, err := b.lineAt(mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= hi || line == "" {
+			hi = mid
+			continue
+		}
+
+		if strings.ToUpper(line[:min(len(line), len(target))]) < target {
+			lo = start + int64(len(line))
+		} else {
+			hi = mid
+		}
+	}
+
+	_, line, err := b.lineAt(lo)
+	if err != nil {
+		return 0, err
+	}
+
+	hash, count, ok := strings.Cut(strings.TrimSpace(line), ":")
+	if !ok || strings.ToUpper(hash) != target {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return 0, fmt.Errorf("invalid breach file line %q", line)
+	}
+	return n, nil
+}
+
+// Looks a single password up, opening the configured dump for it
+func CheckBreachedPassword(path, password string) (int, error) {
+	breaches, err := OpenBreachFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer breaches.Close()
+
+	return breaches.Count(password)
+}
+
+// Breach counts of all entries that appear in the dump, keyed by entry ID
+func ScanBreachedEntries(path string, entries []Entry) (map[string]int, error) {
+	breaches, err := OpenBreachFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer breaches.Close()
+
+	found := make(map[string]int)
+	for _, entry := range entries {
+		count, err := breaches.Count(entry.Password)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			found[entry.ID] = count
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBreachFileCount(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "breach", "pwned_sample.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variants := map[string]string{
+		"unix":                string(data),
+		"windows":             strings.ReplaceAll(string(data), "\n", "\r\n"),
+		"no trailing newline": strings.TrimSuffix(string(data), "\n"),
+	}
+
+	tests := []struct {
+		password string
+		count    int
+	}{
+		{"password", 9545824},
+		{"hunter2", 28906},
+		{"trustno1", 434592},
+		// First and last lines of the file
+		{"sample-25", 26},
+		{"sample-10", 11},
+		{"not in the sample", 0},
+		// Hashes sorting before the first and after the last line
+		{"absent-67", 0},
+		{"absent-323", 0},
+	}
+
+	for name, content := range variants {
+		path := filepath.Join(t.TempDir(), "pwned.txt")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		breaches, err := OpenBreachFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range tests {
+			count, err := breaches.Count(tt.password)
+			if err != nil {
+				t.Errorf("%s, %q: %v", name, tt.password, err)
+				continue
+			}
+			if count != tt.count {
+				t.Errorf("%s, %q: count %d, want %d", name, tt.password, count, tt.count)
+			}
+		}
+		breaches.Close()
+	}
+}
+
+// Every sample password the fixture was made from is found with its own count
+func TestBreachFileEveryLine(t *testing.T) {
+	breaches, err := OpenBreachFile(filepath.Join("testdata", "breach", "pwned_sample.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer breaches.Close()
+
+	for i := 0; i < 40; i++ {
+		count, err := breaches.Count("sample-" + strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != i+1 {
+			t.Errorf("sample-%d: count %d, want %d", i, count, i+1)
+		}
+	}
+}
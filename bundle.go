@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+)
+
+// Marks the plaintext of a share bundle, so a whole vault export is not taken for one
+const entryBundleKind = "go_pass_manager/entry"
+
+// A single entry shared outside of any vault, stored as age encrypted JSON
+type EntryBundle struct {
+	Kind  string `json:"kind"`
+	Entry Entry  `json:"entry"`
+}
+
+// Writes entry into a new bundle file for recipients
+func WriteEntryBundle(entry Entry, out string, recipients []age.Recipient) error {
+	// The receiving vault assigns its own ID, previous passwords are not shared
+	entry.ID = ""
+	entry.History = nil
+
+	plaintext, err := json.Marshal(EntryBundle{Kind: entryBundleKind, Entry: entry})
+	if err != nil {
+		return err
+	}
+	defer wipeBytes(plaintext)
+
+	var encrypted bytes.Buffer
+	writer, err := age.Encrypt(&encrypted, recipients...)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(plaintext); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return writeFileExclusive(expandHome(out), encrypted.Bytes(), 0600)
+}
+
+// Reads the entry of a bundle written by WriteEntryBundle
+func ReadEntryBundle(path string, identities []age.Identity) (Entry, error) {
+	file, err := os.Open(expandHome(path))
+	if err != nil {
+		return Entry{}, err
+	}
+	defer file.Close()
+
+	reader, err := age.Decrypt(file, identities...)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	plaintext, err := io.ReadAll(reader)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer wipeBytes(plaintext)
+
+	var bundle EntryBundle
+	if err := json.Unmarshal(plaintext, &bundle); err != nil || bundle.Kind != entryBundleKind {
+		return Entry{}, fmt.Errorf("not an entry bundle")
+	}
+	if bundle.Entry.Title == "" {
+		return Entry{}, fmt.Errorf("bundle entry has no title")
+	}
+	return bundle.Entry, nil
+}
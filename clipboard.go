@@ -0,0 +1,14 @@
+package main
+
+import (
+	"github.com/atotto/clipboard"
+	"github.com/muesli/termenv"
+)
+
+// Copies text to the system clipboard, or through the terminal with OSC 52
+// when there is no clipboard tool, e.g. over SSH
+func copyToClipboard(text string) {
+	if err := clipboard.WriteAll(text); err != nil {
+		termenv.Copy(text)
+	}
+}
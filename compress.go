@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression names stored in Header.Crypto.Compression
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+
+	DefaultCompression = CompressionGzip
+)
+
+// Older files spell the names differently, e.g. "GZip"
+func normalizeCompression(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case CompressionNone:
+		return CompressionNone, nil
+	case CompressionGzip:
+		return CompressionGzip, nil
+	case CompressionZstd:
+		return CompressionZstd, nil
+	}
+	return "", fmt.Errorf("unknown compression %q, expected %q, %q or %q", name, CompressionNone, CompressionGzip, CompressionZstd)
+}
+
+func compressPayload(data []byte, compression string) ([]byte, error) {
+	name, err := normalizeCompression(compression)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		w, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer w.Close()
+		return w.EncodeAll(data, nil), nil
+	}
+	return data, nil
+}
+
+func decompressPayload(data []byte, compression string) ([]byte, error) {
+	name, err := normalizeCompression(compression)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("ошибка распаковки: %v", err)
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case CompressionZstd:
+		r, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		out, err := r.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка распаковки: %v", err)
+		}
+		return out, nil
+	}
+	return data, nil
+}
@@ -4,11 +4,49 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"os"
+	"runtime"
+	"time"
 
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Cipher names stored in Header.Crypto.Cipher
+const (
+	// Legacy unauthenticated AES-256-CTR, only read and upgraded on save
+	CipherAES256CTR         = "AES-256"
+	CipherAES256GCM         = "AES-256-GCM"
+	CipherXChaCha20Poly1305 = "XChaCha20-Poly1305"
+
+	DefaultCipher = CipherAES256GCM
+)
+
+const KDFArgon2id = "argon2id"
+
+// Argon2id parameters used before they were stored in the header
+const (
+	legacyKDFTime        = 1
+	legacyKDFMemory      = 64 * 1024
+	legacyKDFParallelism = 4
+)
+
+// Upper bound for the calibrated time cost
+const maxKDFTime = 64
+
+// Known plaintext sealed into Meta.Verifier to check a derived key on unlock
+var keyVerifierPlaintext = []byte("go_pass_manager key verifier v1")
+
+// Version byte prepended to every AEAD ciphertext
+const (
+	ciphertextAES256GCM         byte = 1
+	ciphertextXChaCha20Poly1305 byte = 2
 )
 
 func GenerateSalt() ([]byte, error) {
@@ -17,30 +55,104 @@ func GenerateSalt() ([]byte, error) {
 	return salt, err
 }
 
-// Функция для шифрования данных с AES-256
-func EncryptAES256(plaintext, key []byte) (string, error) {
-	// Создаем блочный шифр
-	block, err := aes.NewCipher(key)
+// Random 256-bit key that encrypts the vault payload
+func GenerateDataKey() ([]byte, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+// Overwrites key material that is no longer needed
+func wipeBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Legacy ciphers predate the versioned ciphertext format
+func IsLegacyCipher(cipherName string) bool {
+	return cipherName == "" || cipherName == CipherAES256CTR
+}
+
+func cipherVersion(cipherName string) (byte, error) {
+	switch cipherName {
+	case CipherAES256GCM:
+		return ciphertextAES256GCM, nil
+	case CipherXChaCha20Poly1305:
+		return ciphertextXChaCha20Poly1305, nil
+	}
+	return 0, fmt.Errorf("unsupported cipher %q", cipherName)
+}
+
+func newAEAD(version byte, key []byte) (cipher.AEAD, error) {
+	switch version {
+	case ciphertextAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case ciphertextXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	}
+	return nil, fmt.Errorf("unknown ciphertext version %d", version)
+}
+
+// Encrypt seals plaintext with the AEAD named by cipherName.
+// Layout before base64: version byte || nonce || ciphertext with tag
+func Encrypt(plaintext, key []byte, cipherName string) (string, error) {
+	version, err := cipherVersion(cipherName)
 	if err != nil {
 		return "", err
 	}
 
-	// Генерируем случайный IV
-	ciphertext := make([]byte, aes.BlockSize+len(plaintext))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	aead, err := newAEAD(version, key)
+	if err != nil {
 		return "", err
 	}
 
-	// Шифруем данные
-	//TODO use AEAD instead NewCtr. Need to read more about it
-	stream := cipher.NewCTR(block, iv)
-	stream.XORKeyStream(ciphertext[aes.BlockSize:], plaintext)
+	out := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	out[0] = version
+	if _, err := io.ReadFull(rand.Reader, out[1:]); err != nil {
+		return "", err
+	}
+
+	out = aead.Seal(out, out[1:], plaintext, nil)
 
-	// Кодируем в base64 для хранения в JSON
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+	return base64.StdEncoding.EncodeToString(out), nil
 }
 
+// Decrypt opens a ciphertext produced by Encrypt, the cipher is taken from its version byte
+func Decrypt(encryptedData string, key []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encryptedData)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 1 {
+		return nil, fmt.Errorf("зашифрованные данные слишком короткие")
+	}
+
+	aead, err := newAEAD(data[0], key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 1+aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("зашифрованные данные слишком короткие")
+	}
+
+	nonce := data[1 : 1+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, data[1+aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: data is corrupted or the key is wrong")
+	}
+
+	return plaintext, nil
+}
+
+// Decrypts legacy AES-256-CTR data written before the switch to AEAD.
+// CTR has no integrity check, so a wrong key or corrupted data is not detected here
 func DecryptAES256(encryptedData string, key []byte) (string, error) {
 	// Декодируем base64
 	ciphertext, err := base64.StdEncoding.DecodeString(encryptedData)
@@ -70,12 +182,131 @@ func DecryptAES256(encryptedData string, key []byte) (string, error) {
 	return string(ciphertext), nil
 }
 
-func MakeHash(title, masterPassword string) []byte {
-	return argon2.IDKey([]byte(title), []byte(masterPassword), 1, 64*1024, 4, 32)
+// Password check of files created before Meta.Verifier, only used to migrate them.
+// It hashes the db title with the master password as salt
+func legacyTitleHash(title, masterPassword string) string {
+	return fmt.Sprintf("%x", argon2.IDKey([]byte(title), []byte(masterPassword), legacyKDFTime, legacyKDFMemory, legacyKDFParallelism, 32))
+}
+
+// Seals the known verifier plaintext with key
+func MakeVerifier(key []byte, cipherName string) (string, error) {
+	return Encrypt(keyVerifierPlaintext, key, cipherName)
+}
+
+// Reports whether key opens the verifier, AEAD authentication rejects any other key
+func CheckVerifier(verifier string, key []byte) bool {
+	plaintext, err := Decrypt(verifier, key)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(plaintext, keyVerifierPlaintext) == 1
+}
+
+// KDF parameters for files written before they were stored in the header.
+// Those files used the hex salt string itself as the salt bytes
+func legacyKDFParams(meta Meta) KDFParams {
+	return KDFParams{
+		Algorithm:   KDFArgon2id,
+		Time:        legacyKDFTime,
+		Memory:      legacyKDFMemory,
+		Parallelism: legacyKDFParallelism,
+		Salt:        hex.EncodeToString([]byte(meta.Salt)),
+	}
+}
+
+// Combines the master password with the hash of a key file, so both are needed to derive the key.
+// Without a key file the password is used as is, which keeps existing files readable
+func CompositeSecret(masterPassword string, keyFileHash []byte) []byte {
+	if keyFileHash == nil {
+		return []byte(masterPassword)
+	}
+
+	passwordHash := sha256.Sum256([]byte(masterPassword))
+	h := sha256.New()
+	h.Write(passwordHash[:])
+	h.Write(keyFileHash)
+	return h.Sum(nil)
+}
+
+// SHA-256 of the whole key file content
+func HashKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения ключевого файла: %v", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("key file is empty")
+	}
+
+	hash := sha256.Sum256(data)
+	return hash[:], nil
+}
+
+// Writes 32 random bytes as hex to a new key file, an existing file is never overwritten
+func GenerateKeyFile(path string) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("ошибка создания ключевого файла: %v", err)
+	}
+
+	if _, err := fmt.Fprintf(f, "%x\n", secret); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 // Функция для генерации ключа из мастер-пароля
-func GenerateKey(masterPassword string, salt []byte) []byte {
-	// time=1, memory=64MB, threads=4, keyLen=32
-	return argon2.IDKey([]byte(masterPassword), salt, 1, 64*1024, 4, 32)
+func GenerateKey(secret []byte, params KDFParams) ([]byte, error) {
+	if params.Algorithm != KDFArgon2id {
+		return nil, fmt.Errorf("unsupported KDF %q", params.Algorithm)
+	}
+	if params.Time == 0 || params.Memory == 0 || params.Parallelism == 0 {
+		return nil, fmt.Errorf("invalid KDF parameters")
+	}
+
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KDF salt: %v", err)
+	}
+
+	return argon2.IDKey(secret, salt, params.Time, params.Memory, params.Parallelism, 32), nil
+}
+
+// Picks Argon2id parameters so that one derivation with memoryKiB takes about target on this machine
+func CalibrateKDF(target time.Duration, memoryKiB uint32) (KDFParams, error) {
+	salt, err := GenerateSalt()
+	if err != nil {
+		return KDFParams{}, fmt.Errorf("ошибка генерации соли: %v", err)
+	}
+
+	parallelism := uint8(min(max(runtime.NumCPU(), 1), legacyKDFParallelism))
+
+	// Best of two single-pass runs to smooth out scheduling noise
+	var elapsed time.Duration
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		argon2.IDKey([]byte("calibration"), salt, 1, memoryKiB, parallelism, 32)
+		if d := time.Since(start); i == 0 || d < elapsed {
+			elapsed = d
+		}
+	}
+
+	timeCost := uint32(1)
+	if elapsed > 0 {
+		timeCost = uint32(min(max(int64((target+elapsed/2)/elapsed), 1), maxKDFTime))
+	}
+
+	return KDFParams{
+		Algorithm:   KDFArgon2id,
+		Time:        timeCost,
+		Memory:      memoryKiB,
+		Parallelism: parallelism,
+		Salt:        hex.EncodeToString(salt),
+	}, nil
 }
@@ -9,7 +9,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/charmbracelet/bubbles/table"
 	"github.com/knadh/koanf/parsers/toml"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/v2"
@@ -19,17 +18,67 @@ import (
 
 // Struct for app config
 type AppConfig struct {
-	DBsFolder string `koanf:"dbs_folder"`
+	DBsFolder   string `koanf:"dbs_folder"`
+	Cipher      string `koanf:"cipher"`
+	Compression string `koanf:"compression"`
+	// Target time of one key derivation for new databases
+	UnlockTime time.Duration `koanf:"unlock_time"`
+	// Argon2id memory cost for new databases in MiB
+	KDFMemory uint32 `koanf:"kdf_memory_mib"`
+	// Defaults of the record password generator
+	Generator PasswordPolicy `koanf:"generator"`
+	// Defaults of the master passphrase generator
+	Passphrase PassphrasePolicy `koanf:"passphrase"`
+	// Lowest strength score from 0 to 4 accepted for master passwords, 0 accepts any
+	MinMasterScore int `koanf:"min_master_score"`
+	// Local HIBP Pwned Passwords file, SHA-1 ordered by hash
+	BreachFile string `koanf:"breach_file"`
+	// age identity that opens team vaults this user is a member of
+	IdentityFile string `koanf:"identity_file"`
+	// Encrypted copies taken before each save, by default in .backups of the dbs folder
+	BackupDir string `koanf:"backup_dir"`
+	// Backups kept per vault, 0 disables them
+	BackupCount int `koanf:"backup_count"`
 }
 
+const (
+	defaultUnlockTime = time.Second
+	defaultKDFMemory  = 64
+
+	defaultIdentityFile = "~/.config/go_pwd_manager_identity.txt"
+	defaultBackupCount  = 10
+)
+
 // Структуры для парсинга JSON
+// Only Header is stored in cleartext, the Database is serialized and encrypted into Payload
 type PasswordFile struct {
-	Header   Header   `json:"header"`
-	Database Database `json:"database"`
+	// Format version, see schemaMigrations. Files without one are from before versioning
+	Schema  int    `json:"schema,omitempty"`
+	Header  Header `json:"header"`
+	Payload string `json:"payload,omitempty"`
+	// Legacy cleartext database with per-entry encrypted passwords, moved into Payload on save
+	Database *Database `json:"database,omitempty"`
+	// HMAC over the rest of the file, keyed from the data key
+	MAC string `json:"mac,omitempty"`
+
+	// The file as read from disk, which the MAC is checked against
+	raw []byte
 }
 
 type Header struct {
-	Crypto Crypto `json:"crypto"`
+	Crypto Crypto    `json:"crypto"`
+	Slots  []KeySlot `json:"slots,omitempty"`
+	// Checks the data key, e.g. one reconstructed outside of a slot
+	Verifier string `json:"verifier,omitempty"`
+	// Grows with every save, an older revision than seen before means a rolled back file
+	Revision uint64 `json:"revision,omitempty"`
+	// Names the vault in the local revision list, see vaultID
+	VaultID string `json:"vault_id,omitempty"`
+	// How the data key is expanded into subkeys, see DeriveSubkeys
+	KeyDerivation int `json:"key_derivation,omitempty"`
+	// Legacy single key derived from the master password, moved to Slots on unlock
+	KDF     *KDFParams `json:"kdf,omitempty"`
+	KeyFile bool       `json:"key_file,omitempty"`
 }
 
 type Crypto struct {
@@ -37,16 +86,31 @@ type Crypto struct {
 	Compression string `json:"compression"`
 }
 
+type KDFParams struct {
+	Algorithm   string `json:"algorithm"`
+	Time        uint32 `json:"time"`
+	Memory      uint32 `json:"memory"` // KiB
+	Parallelism uint8  `json:"parallelism"`
+	Salt        string `json:"salt"` // hex
+}
+
 type Database struct {
 	Meta    Meta    `json:"meta"`
 	Entries []Entry `json:"entries"`
+	// Hex data keys the vault had before re-keys, newest first. They open older backups
+	PreviousKeys []string `json:"previous_keys,omitempty"`
 }
 
 type Meta struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
-	Hash        string `json:"hash"`
-	Salt        string `json:"salt"`
+	Hash        string `json:"hash,omitempty"`     // legacy, replaced by Header.Verifier
+	Salt        string `json:"salt,omitempty"`     // legacy, moved to Header.KDF
+	Verifier    string `json:"verifier,omitempty"` // legacy, moved to Header.Verifier
+	// Versions kept per record, defaultHistoryDepth when unset
+	HistoryDepth *int `json:"history_depth,omitempty"`
+	// Sets of recovery shares split from the current data key
+	RecoveryShares int `json:"recovery_shares,omitempty"`
 }
 
 type Entry struct {
@@ -57,10 +121,20 @@ type Entry struct {
 	URL      string    `json:"url"`
 	Notes    string    `json:"notes"`
 	Created  time.Time `json:"created"`
+	// otpauth:// URI or base32 TOTP secret
+	OTP string `json:"otp,omitempty"`
+	// Previous versions, newest first
+	History []EntryVersion `json:"history,omitempty"`
 }
 
 func ReadConfigFile() AppConfig {
-	var config AppConfig
+	// Keys missing from the file keep these defaults
+	config := AppConfig{
+		Generator:    DefaultPasswordPolicy(),
+		Passphrase:   DefaultPassphrasePolicy(),
+		IdentityFile: defaultIdentityFile,
+		BackupCount:  defaultBackupCount,
+	}
 
 	dirname, err := os.UserHomeDir()
 	if err != nil {
@@ -75,11 +149,37 @@ func ReadConfigFile() AppConfig {
 
 	k.Unmarshal("", &config)
 
-	config.DBsFolder = strings.Replace(config.DBsFolder, "~", dirname, 1)
+	config.DBsFolder = expandHome(config.DBsFolder)
+	config.IdentityFile = expandHome(config.IdentityFile)
+	config.BackupDir = expandHome(config.BackupDir)
+
+	if config.BackupDir == "" {
+		config.BackupDir = filepath.Join(config.DBsFolder, ".backups")
+	}
+
+	if config.UnlockTime <= 0 {
+		config.UnlockTime = defaultUnlockTime
+	}
+	if config.KDFMemory == 0 {
+		config.KDFMemory = defaultKDFMemory
+	}
 
 	return config
 }
 
+// Replaces a leading ~ with the user home folder
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+
+	dirname, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return dirname + path[1:]
+}
+
 func ReadDBsFolder(folderPath string) ([]string, error) {
 	var temp []string
 	data, err := os.ReadDir(folderPath)
@@ -97,32 +197,88 @@ func ReadDBsFolder(folderPath string) ([]string, error) {
 	return temp, nil
 }
 
-func IsFileHashValid(filename, masterPassword string) (isOk bool, salt []byte, err error) {
-	data, err := os.ReadFile(filename)
+// Key derivation input for the master password and an optional key file
+func masterSecret(masterPassword, keyFile string) ([]byte, error) {
+	if keyFile == "" {
+		return CompositeSecret(masterPassword, nil), nil
+	}
+
+	keyFileHash, err := HashKeyFile(expandHome(keyFile))
 	if err != nil {
-		return false, nil, fmt.Errorf("ошибка чтения файла: %v", err)
+		return nil, err
 	}
+	return CompositeSecret(masterPassword, keyFileHash), nil
+}
 
-	// Парсим JSON
-	var passwordFile PasswordFile
-	err = json.Unmarshal(data, &passwordFile)
+// Reads only the cleartext header, e.g. to find out whether a key file is needed
+func ReadPasswordFileHeader(filename string) (Header, error) {
+	passwordFile, err := loadPasswordFile(filename)
 	if err != nil {
-		return false, nil, fmt.Errorf("ошибка парсинга JSON: %v", err)
+		return Header{}, err
 	}
+	return passwordFile.Header, nil
+}
 
-	hash := MakeHash(passwordFile.Database.Meta.Name, masterPassword)
-	if fmt.Sprintf("%x", hash) != passwordFile.Database.Meta.Hash {
-		return false, nil, nil
-	} else {
-		return true, []byte(passwordFile.Database.Meta.Salt), nil
+// Checks the master password and key file of a file without key slots
+// and returns the derived key when they are valid
+func checkLegacyPassword(passwordFile *PasswordFile, masterPassword, keyFile string) (isOk bool, key []byte, err error) {
+	if passwordFile.Header.KeyFile && keyFile == "" {
+		return false, nil, fmt.Errorf("database requires a key file")
 	}
+	if !passwordFile.Header.KeyFile {
+		keyFile = ""
+	}
+
+	secret, err := masterSecret(masterPassword, keyFile)
+	if err != nil {
+		return false, nil, err
+	}
+
+	verifier := passwordFile.Header.Verifier
+	if verifier == "" && passwordFile.Database != nil {
+		meta := passwordFile.Database.Meta
+		verifier = meta.Verifier
+
+		// Files without a verifier still carry the legacy title hash, the verifier is added on next save
+		if verifier == "" && legacyTitleHash(meta.Name, masterPassword) != meta.Hash {
+			return false, nil, nil
+		}
+	}
+
+	key, err = GenerateKey(secret, kdfParams(passwordFile))
+	if err != nil {
+		return false, nil, err
+	}
+
+	// Meta verifiers predate subkeys, the header one follows the key derivation version
+	if verifier != "" {
+		valid := CheckVerifier(verifier, key)
+		if verifier == passwordFile.Header.Verifier {
+			valid = passwordFile.Header.CheckKey(key)
+		}
+		if !valid {
+			return false, nil, nil
+		}
+	}
+
+	if verifier == "" && passwordFile.Database == nil {
+		return false, nil, fmt.Errorf("file has no key verifier")
+	}
+
+	return true, key, nil
 }
 
-// Функция для чтения файла
-func ReadPasswordFile(filename string, key []byte) ([]table.Row, error) {
-	var decryptedData []table.Row
+func kdfParams(passwordFile *PasswordFile) KDFParams {
+	if passwordFile.Header.KDF != nil {
+		return *passwordFile.Header.KDF
+	}
+	if passwordFile.Database != nil {
+		return legacyKDFParams(passwordFile.Database.Meta)
+	}
+	return KDFParams{}
+}
 
-	//TODO filePath := filepath.Join(config.folder, filename)
+func loadPasswordFile(filename string) (*PasswordFile, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка чтения файла: %v", err)
@@ -135,84 +291,359 @@ func ReadPasswordFile(filename string, key []byte) ([]table.Row, error) {
 		return nil, fmt.Errorf("ошибка парсинга JSON: %v", err)
 	}
 
-	for _, d := range passwordFile.Database.Entries {
-		dec_d, err := DecryptAES256(d.Password, key)
+	if passwordFile.Payload == "" && passwordFile.Database == nil {
+		return nil, fmt.Errorf("file has no database")
+	}
+	// Legacy databases are not covered by the MAC. No file with key slots or any field written
+	// since the MAC ever had one, so a cleartext database next to them was planted
+	if passwordFile.Database != nil && passwordFile.hasMACEraFields() {
+		return nil, fmt.Errorf("file integrity check failed, a legacy database in a versioned file")
+	}
+	passwordFile.raw = data
+
+	if passwordFile.Schema > currentSchema {
+		return nil, fmt.Errorf("file schema %d is newer than supported %d, update the app", passwordFile.Schema, currentSchema)
+	}
+
+	return &passwordFile, nil
+}
+
+// Loads filename and decrypts its database with key, entry passwords are returned in plaintext
+func openPasswordFile(filename string, key []byte) (*PasswordFile, *Database, error) {
+	passwordFile, err := loadPasswordFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if passwordFile.Payload == "" {
+		db, err := decryptLegacyDatabase(passwordFile, key)
 		if err != nil {
-			// err = err
-			break
+			return nil, nil, err
+		}
+		if err := migrateVault(passwordFile, db); err != nil {
+			return nil, nil, err
 		}
-		decryptedData = append(decryptedData, table.Row{d.Title, dec_d})
+		return passwordFile, db, nil
 	}
 
-	return decryptedData, nil
-}
+	subkeys, err := DeriveSubkeys(key, passwordFile.Header.KeyDerivation)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer subkeys.Wipe()
 
-func AddToPasswordFile(dbsFolder, filename, title, password string, key []byte) error {
-	data, err := os.ReadFile(filename)
+	plaintext, err := Decrypt(passwordFile.Payload, subkeys.Entries)
 	if err != nil {
-		return fmt.Errorf("ошибка чтения файла: %v", err)
+		return nil, nil, fmt.Errorf("failed to decrypt database: %v", err)
 	}
 
-	// Парсим JSON
-	var passwordFile PasswordFile
-	err = json.Unmarshal(data, &passwordFile)
+	// Checked after decryption, so a wrong key is not reported as tampering,
+	// and before the header is used to decompress the payload
+	if err := verifyFileMAC(passwordFile, subkeys.MAC); err != nil {
+		return nil, nil, err
+	}
+
+	plaintext, err = decompressPayload(plaintext, passwordFile.Header.Crypto.Compression)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var db Database
+	if err := json.Unmarshal(plaintext, &db); err != nil {
+		return nil, nil, fmt.Errorf("ошибка парсинга JSON: %v", err)
+	}
+
+	if err := migrateVault(passwordFile, &db); err != nil {
+		return nil, nil, err
+	}
+
+	return passwordFile, &db, nil
+}
+
+// Decrypts entry passwords of a file written before the database payload was encrypted as a whole
+func decryptLegacyDatabase(passwordFile *PasswordFile, key []byte) (*Database, error) {
+	db := *passwordFile.Database
+	db.Entries = make([]Entry, len(passwordFile.Database.Entries))
+
+	for i, e := range passwordFile.Database.Entries {
+		var password string
+		if IsLegacyCipher(passwordFile.Header.Crypto.Cipher) {
+			decrypted, err := DecryptAES256(e.Password, key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt %q: %v", e.Title, err)
+			}
+			password = decrypted
+		} else {
+			decrypted, err := Decrypt(e.Password, key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt %q: %v", e.Title, err)
+			}
+			password = string(decrypted)
+		}
+
+		e.Password = password
+		db.Entries[i] = e
+	}
+
+	return &db, nil
+}
+
+// Encrypts db into the payload with key and returns the serialized file in the current format.
+// Legacy header fields are upgraded on the way
+func sealPasswordFile(passwordFile *PasswordFile, db *Database, key []byte) ([]byte, error) {
+	if err := migrateVault(passwordFile, db); err != nil {
+		return nil, err
+	}
+	if IsLegacyCipher(passwordFile.Header.Crypto.Cipher) {
+		passwordFile.Header.Crypto.Cipher = DefaultCipher
+	}
+	cipherName := passwordFile.Header.Crypto.Cipher
+
+	// Older derivations are upgraded, the verifier moves to its own subkey
+	if passwordFile.Header.KeyDerivation != currentKeyDerivation {
+		passwordFile.Header.KeyDerivation = currentKeyDerivation
+		passwordFile.Header.Verifier = ""
+	}
+
+	subkeys, err := DeriveSubkeys(key, currentKeyDerivation)
 	if err != nil {
-		return fmt.Errorf("ошибка парсинга JSON: %v", err)
+		return nil, err
 	}
+	defer subkeys.Wipe()
 
-	for _, e := range passwordFile.Database.Entries {
-		if e.Title == title {
-			return fmt.Errorf("duplicated title")
+	if passwordFile.Header.Verifier == "" {
+		verifier, err := MakeVerifier(subkeys.Verifier, cipherName)
+		if err != nil {
+			return nil, err
 		}
+		passwordFile.Header.Verifier = verifier
 	}
 
-	hashedPassword, err := EncryptAES256([]byte(password), key)
+	compression, err := normalizeCompression(passwordFile.Header.Crypto.Compression)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	plaintext, err := json.Marshal(db)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сериализации: %v", err)
+	}
+
+	plaintext, err = compressPayload(plaintext, compression)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := Encrypt(plaintext, subkeys.Entries, cipherName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Continue after the highest revision seen, so saving a rolled back file does not reuse revisions
+	passwordFile.Header.VaultID = passwordFile.vaultID(key)
+	seen, err := seenRevision(passwordFile.Header.VaultID)
+	if err != nil {
+		return nil, err
 	}
 
-	entry := Entry{ID: uuid.NewString(), Title: title, Password: hashedPassword}
+	passwordFile.Header.Crypto.Compression = compression
+	passwordFile.Header.Revision = max(passwordFile.Header.Revision, seen) + 1
+	passwordFile.Payload = payload
 
-	passwordFile.Database.Entries = append(passwordFile.Database.Entries, entry)
+	passwordFile.MAC = ""
+	unsigned, err := json.Marshal(passwordFile)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сериализации: %v", err)
+	}
+
+	passwordFile.MAC, err = fileMAC(unsigned, subkeys.MAC)
+	if err != nil {
+		return nil, err
+	}
 
-	newData, err := json.MarshalIndent(passwordFile, "", "  ")
+	data, err := json.MarshalIndent(passwordFile, "", "  ")
 	if err != nil {
-		return fmt.Errorf("ошибка сериализации: %v", err)
+		return nil, fmt.Errorf("ошибка сериализации: %v", err)
 	}
 
-	err = os.WriteFile(filepath.Join(dbsFolder, filename), newData, 0644)
+	return data, nil
+}
+
+// Функция для чтения файла.
+// A rolled back file still returns its entries together with a *RollbackError
+func ReadPasswordFile(filename string, key []byte) ([]Entry, error) {
+	// Taken before reading, a change in between makes the next save refuse rather than clobber it
+	stamp, err := statVault(filename, 0)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения файла: %v", err)
+	}
+
+	passwordFile, db, err := openPasswordFile(filename, key)
+	if err != nil {
+		return nil, err
+	}
+
+	stamp.Revision = passwordFile.Header.Revision
+	rememberStamp(filename, stamp)
+
+	id := passwordFile.vaultID(key)
+	seen, err := seenRevision(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seen revisions: %v", err)
+	}
+	if passwordFile.Header.Revision < seen {
+		return db.Entries, &RollbackError{Revision: passwordFile.Header.Revision, Seen: seen}
+	}
+
+	if err := recordRevision(id, passwordFile.Header.Revision); err != nil {
+		return nil, fmt.Errorf("failed to record vault revision: %v", err)
+	}
+
+	return db.Entries, nil
+}
+
+// Path of a vault listed in the dbs folder. The UI resolves it once when the vault is picked,
+// so every read and write goes to the same file
+func VaultPath(dbsFolder, filename string) string {
+	return filepath.Join(dbsFolder, filename)
+}
+
+// The only way vaults are written: sealed and atomically replaced with owner-only permissions
+func saveVault(path string, passwordFile *PasswordFile, db *Database, key []byte) error {
+	if err := checkStamp(path); err != nil {
+		return err
+	}
+
+	data, err := sealPasswordFile(passwordFile, db, key)
+	if err != nil {
+		return err
+	}
+
+	// Only once the new file is ready, so a failed save does not rotate out a good backup
+	if err := backupBeforeMigrate(path); err != nil {
+		return fmt.Errorf("failed to back up vault before migration: %v", err)
+	}
+	if err := backupVault(path); err != nil {
+		return fmt.Errorf("failed to back up vault: %v", err)
+	}
+
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("ошибка записи файла: %v", err)
+	}
+
+	// Our own write is the new baseline for the next save
+	stamp, err := statVault(path, passwordFile.Header.Revision)
 	if err != nil {
-		fmt.Println("Error writing file:", err)
 		return err
 	}
+	rememberStamp(path, stamp)
 	return nil
 }
 
-func RemoveFromPasswordFile(dbsFolder, filename string, selectedIndex int) error {
-	data, err := os.ReadFile(filename)
+// Loads the vault at path, lets update change it and saves it
+func updateVault(path string, key []byte, update func(passwordFile *PasswordFile, db *Database) error) error {
+	passwordFile, db, err := openPasswordFile(path, key)
 	if err != nil {
-		return fmt.Errorf("ошибка чтения файла: %v", err)
+		return err
 	}
 
-	// Парсим JSON
-	var passwordFile PasswordFile
-	err = json.Unmarshal(data, &passwordFile)
+	if err := update(passwordFile, db); err != nil {
+		return err
+	}
+
+	return saveVault(path, passwordFile, db, key)
+}
+
+func AddToPasswordFile(path string, entry Entry, key []byte) error {
+	return updateVault(path, key, func(_ *PasswordFile, db *Database) error {
+		for _, e := range db.Entries {
+			if e.Title == entry.Title {
+				return fmt.Errorf("duplicated title")
+			}
+		}
+
+		entry.ID = uuid.NewString()
+
+		db.Entries = append(db.Entries, entry)
+		return nil
+	})
+}
+
+func RemoveFromPasswordFile(path string, selectedIndex int, key []byte) error {
+	return updateVault(path, key, func(_ *PasswordFile, db *Database) error {
+		if selectedIndex < 0 || selectedIndex >= len(db.Entries) {
+			return fmt.Errorf("record not found")
+		}
+
+		// Find and remove entry
+		entries := db.Entries
+		db.Entries = append(entries[:selectedIndex], entries[selectedIndex+1:]...)
+		return nil
+	})
+}
+
+// Writes data to a temp file in the same folder, syncs it and renames it over filename
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".tmp-*")
 	if err != nil {
-		return fmt.Errorf("ошибка парсинга JSON: %v", err)
+		return err
+	}
+	tmpName := tmp.Name()
+
+	// Remove the temp file unless it was renamed
+	defer os.Remove(tmpName)
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpName, filename); err != nil {
+		return err
 	}
 
-	// Find and remove entry
-	entries := passwordFile.Database.Entries
-	passwordFile.Database.Entries = append(entries[:selectedIndex], entries[selectedIndex+1:]...)
+	// Persist the rename itself
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
 
-	newData, err := json.MarshalIndent(passwordFile, "", "  ")
+// Writes data to a new file, failing when filename already exists, even when it appears
+// while the data is being prepared. A failed write removes the partial file
+func writeFileExclusive(filename string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if os.IsExist(err) {
+		return fmt.Errorf("file already exists")
+	}
 	if err != nil {
-		return fmt.Errorf("ошибка сериализации: %v", err)
+		return err
 	}
 
-	err = os.WriteFile(filepath.Join(dbsFolder, filename), newData, 0644)
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		fmt.Println("Error writing file:", err)
+		os.Remove(filename)
 		return err
 	}
 	return nil
@@ -226,49 +657,77 @@ func fileExists(filename string) bool {
 	return !info.IsDir()
 }
 
-// hash its db title hashed with password
-func CreatePasswordFile(filename string, dbsFolder string, masterPassword string) error {
+// Creates a vault with a random data key, a master password slot and a recovery slot, and returns
+// the recovery code, which is not stored. Entries may be nil and are added like by ImportEntries
+func CreatePasswordFile(filename string, dbsFolder string, masterPassword, keyFile string, crypto Crypto, kdf KDFParams, entries []Entry) (string, error) {
 	filename = filename + ".json"
+	path := VaultPath(dbsFolder, filename)
 
-	hash := MakeHash(filename, masterPassword)
+	if crypto.Cipher == "" {
+		crypto.Cipher = DefaultCipher
+	}
+	if _, err := cipherVersion(crypto.Cipher); err != nil {
+		return "", err
+	}
 
-	salt, err := GenerateSalt()
+	if crypto.Compression == "" {
+		crypto.Compression = DefaultCompression
+	}
+	compression, err := normalizeCompression(crypto.Compression)
 	if err != nil {
-		return fmt.Errorf("ошибка генерации соли: %v", err)
+		return "", err
 	}
+	crypto.Compression = compression
 
-	// Хэшируем мастер-пароль
-	if fileExists(filepath.Join(dbsFolder, filename)) {
-		return fmt.Errorf("file already exists")
+	key, err := GenerateDataKey()
+	if err != nil {
+		return "", err
+	}
+	defer wipeBytes(key)
+
+	masterSlot, err := newPasswordSlot("master", masterPassword, keyFile, kdf, key, crypto.Cipher)
+	if err != nil {
+		return "", err
 	}
 
-	db := &PasswordFile{
+	recoverySlot, recoveryCode, err := newRecoverySlot("recovery", key, crypto.Cipher)
+	if err != nil {
+		return "", err
+	}
+
+	vaultID, err := newVaultID()
+	if err != nil {
+		return "", err
+	}
+
+	passwordFile := &PasswordFile{
 		Header: Header{
-			Crypto: Crypto{
-				Cipher:      "AES-256",
-				Compression: "GZip",
-			},
+			Crypto:  crypto,
+			Slots:   []KeySlot{masterSlot, recoverySlot},
+			VaultID: vaultID,
 		},
-		Database: Database{
-			Meta: Meta{
-				Name:        filename,
-				Description: "Personal password database",
-				Hash:        fmt.Sprintf("%x", hash),
-				Salt:        fmt.Sprintf("%x", salt), // Соль в hex
-			},
-			Entries: []Entry{}, // Пустой массив entries
+	}
+
+	db := &Database{
+		Meta: Meta{
+			Name:        filename,
+			Description: "Personal password database",
 		},
+		Entries: []Entry{}, // Пустой массив entries
 	}
+	// Imported entries go through the same checks as an import into an existing vault
+	mergeEntries(db, entries)
+	passwordFile.Schema = currentSchema
 
-	data, err := json.MarshalIndent(db, "", "  ")
+	data, err := sealPasswordFile(passwordFile, db, key)
 	if err != nil {
-		return fmt.Errorf("ошибка сериализации: %v", err)
+		return "", err
 	}
 
-	err = os.WriteFile(filepath.Join(dbsFolder, filename), data, 0600)
-	if err != nil {
-		return fmt.Errorf("ошибка записи файла: %v", err)
+	// Never replaces a vault created under the same name in the meantime
+	if err := writeFileExclusive(path, data, 0600); err != nil {
+		return "", err
 	}
 
-	return nil
+	return recoveryCode, nil
 }
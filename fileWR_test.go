@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Creating a vault under a taken name leaves the existing one alone
+func TestCreatePasswordFileExists(t *testing.T) {
+	path, key := newTestVault(t)
+
+	kdf, err := recoveryKDFParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreatePasswordFile("vault", filepath.Dir(path), "other", "", Crypto{}, kdf, nil); err == nil {
+		t.Fatal("created a vault over an existing one")
+	}
+
+	entries, err := ReadPasswordFile(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d entries, want 1", len(entries))
+	}
+	if isOk, _, _, _ := UnlockPasswordFile(path, "other", ""); isOk {
+		t.Error("the password of the second vault opens the file")
+	}
+}
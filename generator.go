@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	lowercaseChars = "abcdefghijklmnopqrstuvwxyz"
+	uppercaseChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars     = "0123456789"
+	defaultSymbols = "!#$%&()*+,-./:;<=>?@[]^_{|}~"
+	// Characters easily confused with each other when read or typed by hand
+	ambiguousChars = "Il1|O0o`'\""
+
+	maxGeneratedLength = 156
+)
+
+// Password generator settings, the [generator] table in the app config
+type PasswordPolicy struct {
+	Length           int    `koanf:"length"`
+	Lowercase        bool   `koanf:"lowercase"`
+	Uppercase        bool   `koanf:"uppercase"`
+	Digits           bool   `koanf:"digits"`
+	Symbols          bool   `koanf:"symbols"`
+	SymbolSet        string `koanf:"symbol_set"`
+	ExcludeAmbiguous bool   `koanf:"exclude_ambiguous"`
+	// Every enabled class appears in the password at least once
+	RequireEachClass bool `koanf:"require_each_class"`
+}
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		Length:           20,
+		Lowercase:        true,
+		Uppercase:        true,
+		Digits:           true,
+		Symbols:          true,
+		SymbolSet:        defaultSymbols,
+		ExcludeAmbiguous: true,
+		RequireEachClass: true,
+	}
+}
+
+// Character sets of the enabled classes, without ambiguous characters if asked
+func (p PasswordPolicy) classes() []string {
+	symbols := p.SymbolSet
+	if symbols == "" {
+		symbols = defaultSymbols
+	}
+
+	var classes []string
+	for _, class := range []struct {
+		enabled bool
+		chars   string
+	}{
+		{p.Lowercase, lowercaseChars},
+		{p.Uppercase, uppercaseChars},
+		{p.Digits, digitChars},
+		{p.Symbols, symbols},
+	} {
+		if !class.enabled {
+			continue
+		}
+
+		chars := class.chars
+		if p.ExcludeAmbiguous {
+			chars = strings.Map(func(r rune) rune {
+				if strings.ContainsRune(ambiguousChars, r) {
+					return -1
+				}
+				return r
+			}, chars)
+		}
+		if chars != "" {
+			classes = append(classes, chars)
+		}
+	}
+	return classes
+}
+
+// Uniform random index in [0, n)
+func randomIndex(n int) (int, error) {
+	index, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(index.Int64()), nil
+}
+
+func GeneratePassword(policy PasswordPolicy) (string, error) {
+	classes := policy.classes()
+	if len(classes) == 0 {
+		return "", fmt.Errorf("no character classes enabled")
+	}
+	if policy.Length < 1 || policy.Length > maxGeneratedLength {
+		return "", fmt.Errorf("password length must be between 1 and %d", maxGeneratedLength)
+	}
+	if policy.RequireEachClass && policy.Length < len(classes) {
+		return "", fmt.Errorf("password length %d is too short for %d required classes", policy.Length, len(classes))
+	}
+
+	alphabet := []rune(strings.Join(classes, ""))
+	password := make([]rune, 0, policy.Length)
+
+	if policy.RequireEachClass {
+		for _, class := range classes {
+			chars := []rune(class)
+			index, err := randomIndex(len(chars))
+			if err != nil {
+				return "", err
+			}
+			password = append(password, chars[index])
+		}
+	}
+
+	for len(password) < policy.Length {
+		index, err := randomIndex(len(alphabet))
+		if err != nil {
+			return "", err
+		}
+		password = append(password, alphabet[index])
+	}
+
+	// Fisher-Yates, so the required characters are not always in front
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomIndex(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+
+	return string(password), nil
+}
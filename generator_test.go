@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratePasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy func(p *PasswordPolicy)
+		ok     bool
+	}{
+		{"default", func(p *PasswordPolicy) {}, true},
+		{"no classes", func(p *PasswordPolicy) {
+			p.Lowercase, p.Uppercase, p.Digits, p.Symbols = false, false, false, false
+		}, false},
+		// The only symbol is ambiguous, so no class is left
+		{"only ambiguous symbols", func(p *PasswordPolicy) {
+			p.Lowercase, p.Uppercase, p.Digits = false, false, false
+			p.SymbolSet = "|"
+		}, false},
+		{"zero length", func(p *PasswordPolicy) { p.Length = 0 }, false},
+		{"maximum length", func(p *PasswordPolicy) { p.Length = maxGeneratedLength }, true},
+		{"too long", func(p *PasswordPolicy) { p.Length = maxGeneratedLength + 1 }, false},
+		{"shorter than the required classes", func(p *PasswordPolicy) { p.Length = 3 }, false},
+		{"short without required classes", func(p *PasswordPolicy) {
+			p.Length = 3
+			p.RequireEachClass = false
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := DefaultPasswordPolicy()
+			tt.policy(&policy)
+
+			password, err := GeneratePassword(policy)
+			if (err == nil) != tt.ok {
+				t.Fatalf("generate: %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && len([]rune(password)) != policy.Length {
+				t.Errorf("length %d, want %d", len([]rune(password)), policy.Length)
+			}
+		})
+	}
+}
+
+func TestGeneratePasswordClasses(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy PasswordPolicy
+		// Every password has a character of each of these
+		required []string
+		// No password has any of these characters
+		excluded string
+	}{
+		{
+			name:     "default",
+			policy:   DefaultPasswordPolicy(),
+			required: []string{lowercaseChars, uppercaseChars, digitChars, defaultSymbols},
+			excluded: ambiguousChars,
+		},
+		{
+			name:     "digits and custom symbols",
+			policy:   PasswordPolicy{Length: 4, Digits: true, Symbols: true, SymbolSet: "@", RequireEachClass: true},
+			required: []string{digitChars, "@"},
+			excluded: lowercaseChars + uppercaseChars,
+		},
+		{
+			name:     "ambiguous characters excluded",
+			policy:   PasswordPolicy{Length: 30, Lowercase: true, Uppercase: true, Digits: true, ExcludeAmbiguous: true},
+			excluded: ambiguousChars,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Enough tries that a class missed by chance would show up
+			for i := 0; i < 200; i++ {
+				password, err := GeneratePassword(tt.policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.excluded != "" && strings.ContainsAny(password, tt.excluded) {
+					t.Fatalf("%q has an excluded character", password)
+				}
+				for _, class := range tt.required {
+					if !strings.ContainsAny(password, class) {
+						t.Fatalf("%q has none of %q", password, class)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyClasses(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  PasswordPolicy
+		classes []string
+	}{
+		{"ambiguous kept", PasswordPolicy{Digits: true, Symbols: true, SymbolSet: "|@"},
+			[]string{digitChars, "|@"}},
+		{"ambiguous excluded", PasswordPolicy{Digits: true, Symbols: true, SymbolSet: "|@", ExcludeAmbiguous: true},
+			[]string{"23456789", "@"}},
+		// A class left empty by the exclusion is dropped instead of required
+		{"empty class dropped", PasswordPolicy{Digits: true, Symbols: true, SymbolSet: "|", ExcludeAmbiguous: true},
+			[]string{"23456789"}},
+		{"default symbols", PasswordPolicy{Symbols: true}, []string{defaultSymbols}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			classes := tt.policy.classes()
+			if strings.Join(classes, " ") != strings.Join(tt.classes, " ") {
+				t.Errorf("classes %q, want %q", classes, tt.classes)
+			}
+		})
+	}
+}
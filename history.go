@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Versions kept per record when the vault does not set its own depth
+const defaultHistoryDepth = 10
+
+const maxHistoryDepth = 100
+
+// Values a record had before one edit
+type EntryVersion struct {
+	Username string    `json:"username"`
+	Password string    `json:"password"`
+	URL      string    `json:"url"`
+	Changed  time.Time `json:"changed"`
+}
+
+func (meta Meta) historyDepth() int {
+	if meta.HistoryDepth == nil {
+		return defaultHistoryDepth
+	}
+	return *meta.HistoryDepth
+}
+
+func (entry Entry) version(changed time.Time) EntryVersion {
+	return EntryVersion{
+		Username: entry.Username,
+		Password: entry.Password,
+		URL:      entry.URL,
+		Changed:  changed,
+	}
+}
+
+// Names of the fields that differ between two versions
+func changedFields(a, b EntryVersion) []string {
+	var fields []string
+	if a.Username != b.Username {
+		fields = append(fields, "username")
+	}
+	if a.Password != b.Password {
+		fields = append(fields, "password")
+	}
+	if a.URL != b.URL {
+		fields = append(fields, "url")
+	}
+	return fields
+}
+
+// Applies an edit to the record at selectedIndex. Previous username, password and URL
+// go to the front of its history, which is cut to the vault history depth
+func UpdateEntry(path string, selectedIndex int, updated Entry, key []byte) error {
+	return updateVault(path, key, func(_ *PasswordFile, db *Database) error {
+		if selectedIndex < 0 || selectedIndex >= len(db.Entries) {
+			return fmt.Errorf("record not found")
+		}
+
+		for i, e := range db.Entries {
+			if i != selectedIndex && e.Title == updated.Title {
+				return fmt.Errorf("duplicated title")
+			}
+		}
+
+		entry := &db.Entries[selectedIndex]
+		now := time.Now()
+
+		previous := entry.version(now)
+		if len(changedFields(previous, updated.version(now))) > 0 {
+			entry.History = append([]EntryVersion{previous}, entry.History...)
+		}
+		if depth := db.Meta.historyDepth(); len(entry.History) > depth {
+			entry.History = entry.History[:depth]
+		}
+
+		entry.Title = updated.Title
+		entry.Username = updated.Username
+		entry.Password = updated.Password
+		entry.URL = updated.URL
+		entry.OTP = updated.OTP
+		return nil
+	})
+}
+
+// Brings back a previous version of a record as a new edit, so the current values stay in history
+func RestoreEntryVersion(path string, selectedIndex, versionIndex int, entry Entry, key []byte) error {
+	if versionIndex < 0 || versionIndex >= len(entry.History) {
+		return fmt.Errorf("version not found")
+	}
+
+	version := entry.History[versionIndex]
+	entry.Username = version.Username
+	entry.Password = version.Password
+	entry.URL = version.URL
+	return UpdateEntry(path, selectedIndex, entry, key)
+}
+
+func ReadHistoryDepth(path string, key []byte) (int, error) {
+	_, db, err := openPasswordFile(path, key)
+	if err != nil {
+		return 0, err
+	}
+	return db.Meta.historyDepth(), nil
+}
+
+// Sets how many versions each record keeps, existing histories are cut to it
+func SetHistoryDepth(path string, depth int, key []byte) error {
+	if depth < 0 || depth > maxHistoryDepth {
+		return fmt.Errorf("history depth must be between 0 and %d", maxHistoryDepth)
+	}
+
+	return updateVault(path, key, func(_ *PasswordFile, db *Database) error {
+		db.Meta.HistoryDepth = &depth
+		for i := range db.Entries {
+			if len(db.Entries[i].History) > depth {
+				db.Entries[i].History = db.Entries[i].History[:depth]
+			}
+		}
+		return nil
+	})
+}
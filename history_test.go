@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEntryHistory(t *testing.T) {
+	path, key := newTestVault(t)
+
+	// Each edit pushes the values it replaces to the front of the history
+	for i := 1; i <= 4; i++ {
+		entry := Entry{Title: "GitHub", Username: "octocat", Password: fmt.Sprintf("password %d", i)}
+		if err := UpdateEntry(path, 0, entry, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A save without changes adds no version
+	if err := UpdateEntry(path, 0, Entry{Title: "GitHub", Username: "octocat", Password: "password 4"}, key); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadPasswordFile(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := entries[0].History
+	want := []string{"password 3", "password 2", "password 1", "hunter2"}
+	if len(history) != len(want) {
+		t.Fatalf("%d versions, want %d", len(history), len(want))
+	}
+	for i, version := range history {
+		if version.Password != want[i] {
+			t.Errorf("version %d has password %q, want %q", i, version.Password, want[i])
+		}
+	}
+
+	if err := SetHistoryDepth(path, 2, key); err != nil {
+		t.Fatal(err)
+	}
+	if depth, err := ReadHistoryDepth(path, key); err != nil || depth != 2 {
+		t.Fatalf("depth %d, %v, want 2", depth, err)
+	}
+	entries, err = ReadPasswordFile(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history := entries[0].History; len(history) != 2 || history[1].Password != "password 2" {
+		t.Fatalf("history after trimming %+v, want the 2 newest versions", history)
+	}
+
+	// The restored version is a new edit, the values it replaces stay in history
+	if err := RestoreEntryVersion(path, 0, 1, entries[0], key); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = ReadPasswordFile(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := entries[0]
+	if entry.Password != "password 2" || entry.Username != "octocat" {
+		t.Errorf("restored %q/%q, want octocat/password 2", entry.Username, entry.Password)
+	}
+	if len(entry.History) != 2 || entry.History[0].Password != "password 4" || entry.History[1].Password != "password 3" {
+		t.Errorf("history after the restore %+v", entry.History)
+	}
+
+	if err := RestoreEntryVersion(path, 0, 2, entry, key); err == nil {
+		t.Error("restored a version that is not in the history")
+	}
+	if err := SetHistoryDepth(path, maxHistoryDepth+1, key); err == nil {
+		t.Error("set a history depth above the maximum")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// MAC key label of files without subkeys
+	fileMACLabel = "go_pass_manager file mac v1"
+	vaultIDLabel = "go_pass_manager vault id v1"
+)
+
+// Thrown when a vault is older than a revision this machine has already opened,
+// e.g. the file was replaced with an earlier copy
+type RollbackError struct {
+	Revision uint64
+	Seen     uint64
+}
+
+func (e *RollbackError) Error() string {
+	return fmt.Sprintf("vault revision %d is older than revision %d already seen on this machine, the file may have been replaced with an old copy",
+		e.Revision, e.Seen)
+}
+
+func keyedHash(key []byte, label string, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// HMAC-SHA256 over the canonical form of a serialized file: every field except "mac",
+// object keys sorted and no whitespace. Fields this version does not know are covered as well
+func fileMAC(data []byte, macKey []byte) (string, error) {
+	canonical, err := canonicalFile(data)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(keyedHash(macKey, "", canonical)), nil
+}
+
+func canonicalFile(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Numbers keep their literal form
+	decoder.UseNumber()
+
+	var file map[string]any
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("ошибка парсинга JSON: %v", err)
+	}
+	delete(file, "mac")
+
+	canonical, err := json.Marshal(file)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сериализации: %v", err)
+	}
+	return canonical, nil
+}
+
+// MAC of files before schemaFileMAC, over the known fields of PasswordFile only
+func structFileMAC(passwordFile *PasswordFile, macKey []byte) (string, error) {
+	unsigned := *passwordFile
+	unsigned.MAC = ""
+
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return "", fmt.Errorf("ошибка сериализации: %v", err)
+	}
+
+	return hex.EncodeToString(keyedHash(macKey, "", data)), nil
+}
+
+// Only files from before key slots may lack a MAC. Their header holds nothing that opens the vault
+// to anyone else, while stripping the MAC from a file with slots would let its slots be edited unnoticed
+func verifyFileMAC(passwordFile *PasswordFile, macKey []byte) error {
+	if passwordFile.MAC == "" {
+		if passwordFile.hasMACEraFields() {
+			return fmt.Errorf("file integrity check failed, the MAC is missing")
+		}
+		return nil
+	}
+
+	var expected string
+	var err error
+	if passwordFile.Schema < schemaFileMAC {
+		expected, err = structFileMAC(passwordFile, macKey)
+	} else {
+		expected, err = fileMAC(passwordFile.raw, macKey)
+	}
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(passwordFile.MAC)) {
+		return fmt.Errorf("file integrity check failed, the vault was modified outside of the app")
+	}
+	return nil
+}
+
+// Reports whether the file has anything only written since files carry a MAC
+func (passwordFile *PasswordFile) hasMACEraFields() bool {
+	header := passwordFile.Header
+	return passwordFile.Schema > 0 || passwordFile.MAC != "" || len(header.Slots) > 0 ||
+		header.Revision > 0 || header.VaultID != "" || header.KeyDerivation > 0
+}
+
+// Names the vault in the local revision list without revealing anything about it.
+// Kept in the MAC covered header, so re-keying the vault does not start a new revision list.
+// Files from before it are named by their data key, and keep that name on the next save
+func (passwordFile *PasswordFile) vaultID(key []byte) string {
+	if passwordFile.Header.VaultID != "" {
+		return passwordFile.Header.VaultID
+	}
+	return hex.EncodeToString(keyedHash(key, vaultIDLabel, nil)[:16])
+}
+
+func newVaultID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func revisionsFile() (string, error) {
+	dirname, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dirname, ".config", "go_pwd_manager_revisions.json"), nil
+}
+
+func readRevisions() (map[string]uint64, error) {
+	path, err := revisionsFile()
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make(map[string]uint64)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return revisions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &revisions); err != nil {
+		return nil, fmt.Errorf("ошибка парсинга JSON: %v", err)
+	}
+	return revisions, nil
+}
+
+// Highest revision of the vault opened on this machine, 0 when it was never opened
+func seenRevision(id string) (uint64, error) {
+	revisions, err := readRevisions()
+	if err != nil {
+		return 0, err
+	}
+	return revisions[id], nil
+}
+
+// Instances opening different vaults share the revision list, the lock keeps them
+// from writing over each other's records
+func recordRevision(id string, revision uint64) error {
+	path, err := revisionsFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := waitLockFile(lock); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+
+	revisions, err := readRevisions()
+	if err != nil {
+		return err
+	}
+
+	if revisions[id] >= revision {
+		return nil
+	}
+	revisions[id] = revision
+
+	data, err := json.MarshalIndent(revisions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// Creates a vault with one record and the master password "test" and returns its path and data key
+func newTestVault(t *testing.T) (string, []byte) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+
+	kdf, err := recoveryKDFParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []Entry{{ID: "1", Title: "GitHub", Password: "hunter2"}}
+	if _, err := CreatePasswordFile("vault", dir, "test", "", Crypto{}, kdf, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	path := VaultPath(dir, "vault.json")
+	isOk, key, _, err := UnlockPasswordFile(path, "test", "")
+	if err != nil || !isOk {
+		t.Fatalf("unlock: ok %v, %v", isOk, err)
+	}
+	return path, key
+}
+
+// Rewrites the vault file on disk through its generic JSON form
+func editVaultFile(t *testing.T, path string, edit func(file map[string]any)) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file map[string]any
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+
+	edit(file)
+
+	data, err = json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTamperedVault(t *testing.T) {
+	bob, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	eve, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Points bob's member slot at eve, so a later re-key would wrap the new data key for her
+	swapRecipient := func(file map[string]any) {
+		header := file["header"].(map[string]any)
+		for _, slot := range header["slots"].([]any) {
+			slot := slot.(map[string]any)
+			if slot["recipient"] == bob.Recipient().String() {
+				slot["recipient"] = eve.Recipient().String()
+			}
+		}
+	}
+
+	tests := []struct {
+		name string
+		edit func(file map[string]any)
+	}{
+		{"member recipient", swapRecipient},
+		{"revision", func(file map[string]any) {
+			file["header"].(map[string]any)["revision"] = 1000
+		}},
+		{"compression", func(file map[string]any) {
+			file["header"].(map[string]any)["crypto"].(map[string]any)["compression"] = "none"
+		}},
+		{"unknown field", func(file map[string]any) {
+			file["note"] = "added"
+		}},
+		{"wrong MAC", func(file map[string]any) {
+			file["mac"] = strings.Repeat("0", 64)
+		}},
+		{"MAC removed", func(file map[string]any) {
+			delete(file, "mac")
+		}},
+		// Regression: a cleartext database was read without any MAC check
+		{"payload replaced with a legacy database", func(file map[string]any) {
+			delete(file, "payload")
+			delete(file, "mac")
+			delete(file, "schema")
+			file["header"].(map[string]any)["crypto"].(map[string]any)["cipher"] = "AES-256"
+			file["database"] = map[string]any{
+				"meta": map[string]any{"name": "vault.json"},
+				"entries": []any{map[string]any{
+					"id": "planted", "title": "Bank", "url": "https://evil.example", "password": "",
+				}},
+			}
+		}},
+		// Regression: a file without MAC, schema and revision used to pass as one from before the MAC
+		{"MAC, schema and revision removed", func(file map[string]any) {
+			delete(file, "mac")
+			delete(file, "schema")
+			delete(file["header"].(map[string]any), "revision")
+			swapRecipient(file)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, key := newTestVault(t)
+			if err := AddMember(path, key, "bob", bob.Recipient().String()); err != nil {
+				t.Fatal(err)
+			}
+
+			editVaultFile(t, path, tt.edit)
+
+			// A machine that never opened the vault has no revision to compare with
+			t.Setenv("HOME", t.TempDir())
+
+			if isOk, _, _, err := UnlockPasswordFile(path, "test", ""); err == nil || isOk {
+				t.Errorf("password unlock: ok %v, %v, want an integrity error", isOk, err)
+			}
+			if _, err := ReadPasswordFile(path, key); err == nil || !strings.Contains(err.Error(), "integrity") {
+				t.Errorf("read: %v, want an integrity error", err)
+			}
+			for name, identity := range map[string]*age.X25519Identity{"bob": bob, "eve": eve} {
+				if isOk, _, _, _ := UnlockWithIdentity(path, identity); isOk {
+					t.Errorf("%s unlocked the vault", name)
+				}
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	member, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// Saves the vault again and returns the key that opens it afterwards
+		save func(t *testing.T, path string, key []byte) []byte
+	}{
+		{"save", func(t *testing.T, path string, key []byte) []byte {
+			if err := AddToPasswordFile(path, Entry{Title: "Mail"}, key); err != nil {
+				t.Fatal(err)
+			}
+			return key
+		}},
+		// A re-key must not start a new revision list for the vault
+		{"member removed", func(t *testing.T, path string, key []byte) []byte {
+			header, err := ReadPasswordFileHeader(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var memberID string
+			for _, slot := range header.Slots {
+				if slot.Type == SlotMember {
+					memberID = slot.ID
+				}
+			}
+
+			result, err := RemoveMember(path, key, memberID, "test", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return result.Key
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, key := newTestVault(t)
+			if err := AddMember(path, key, "member", member.Recipient().String()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ReadPasswordFile(path, key); err != nil {
+				t.Fatal(err)
+			}
+
+			old, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			newKey := tt.save(t, path, key)
+			if _, err := ReadPasswordFile(path, newKey); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(path, old, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := ReadPasswordFile(path, key)
+			var rollback *RollbackError
+			if !errors.As(err, &rollback) {
+				t.Fatalf("read: %v, want a RollbackError", err)
+			}
+			if rollback.Revision >= rollback.Seen {
+				t.Errorf("revision %d, seen %d", rollback.Revision, rollback.Seen)
+			}
+			if len(entries) != 1 {
+				t.Errorf("%d entries, want the 1 of the old file", len(entries))
+			}
+
+			// The old file still has the member slot, opening it with that is reported the same way
+			isOk, memberKey, _, err := UnlockWithIdentity(path, member)
+			if err != nil || !isOk {
+				t.Fatalf("member unlock: ok %v, %v", isOk, err)
+			}
+			if _, err := ReadPasswordFile(path, memberKey); !errors.As(err, &rollback) {
+				t.Errorf("member read: %v, want a RollbackError", err)
+			}
+		})
+	}
+}
+
+// Instances recording different vaults at the same time keep each other's records
+func TestRecordRevisionConcurrent(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- recordRevision(fmt.Sprintf("vault%d", i), uint64(i+1))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		seen, err := seenRevision(fmt.Sprintf("vault%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen != uint64(i+1) {
+			t.Errorf("vault%d: seen %d, want %d", i, seen, i+1)
+		}
+	}
+}
+
+// A legacy file swapped in after it was upgraded to key slots is not upgraded again
+func TestLegacyRollback(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	legacy, err := os.ReadFile(filepath.Join("testdata", "schema", "schema0_payload.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "vault.json")
+	if err := os.WriteFile(path, legacy, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	isOk, key, _, err := UnlockPasswordFile(path, "test", "")
+	if err != nil || !isOk {
+		t.Fatalf("unlock: ok %v, %v", isOk, err)
+	}
+	if _, err := ReadPasswordFile(path, key); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, legacy, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	isOk, _, _, err = UnlockPasswordFile(path, "test", "")
+	var rollback *RollbackError
+	if !errors.As(err, &rollback) || isOk {
+		t.Fatalf("unlock of the legacy copy: ok %v, %v, want a RollbackError", isOk, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, legacy) {
+		t.Error("the legacy copy was upgraded")
+	}
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Key slot types
+const (
+	SlotPassword = "password"
+	SlotRecovery = "recovery"
+)
+
+// Random bytes in a recovery code
+const recoveryCodeSize = 20
+
+// A key slot holds the vault data key wrapped with a key derived from one secret,
+// so each password or recovery code opens the vault independently
+type KeySlot struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Label   string    `json:"label"`
+	KDF     KDFParams `json:"kdf"`
+	KeyFile bool      `json:"key_file,omitempty"`
+	// age X25519 public key of a team member slot
+	Recipient string `json:"recipient,omitempty"`
+	// Data key encrypted with the slot key
+	WrappedKey string `json:"wrapped_key"`
+}
+
+// Reports whether the password screen has to offer a key file input
+func (h Header) RequiresKeyFile() bool {
+	if h.KeyFile {
+		return true
+	}
+	for _, slot := range h.Slots {
+		if slot.Type == SlotPassword && slot.KeyFile {
+			return true
+		}
+	}
+	return false
+}
+
+// Password slot that slotID names. Vaults opened with a recovery code, an identity or shares
+// have no master password of their own to change
+func (h Header) masterSlot(slotID string) (int, error) {
+	for i, slot := range h.Slots {
+		if slot.ID == slotID && slot.Type == SlotPassword {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("the vault was not opened with a master password")
+}
+
+// Recovery codes are printed as dash separated groups of four base32 characters
+func NewRecoveryCode() (code string, secret []byte, err error) {
+	secret, err = GenerateDataKey()
+	if err != nil {
+		return "", nil, err
+	}
+	secret = secret[:recoveryCodeSize]
+
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+	var groups []string
+	for i := 0; i < len(encoded); i += 4 {
+		groups = append(groups, encoded[i:min(i+4, len(encoded))])
+	}
+
+	return strings.Join(groups, "-"), secret, nil
+}
+
+// Accepts a recovery code with any case, spaces and dashes
+func ParseRecoveryCode(code string) ([]byte, error) {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(normalized)
+	if err != nil || len(secret) != recoveryCodeSize {
+		return nil, fmt.Errorf("invalid recovery code")
+	}
+	return secret, nil
+}
+
+// Recovery codes carry full entropy, so a single fast Argon2id pass is enough
+func recoveryKDFParams() (KDFParams, error) {
+	salt, err := GenerateSalt()
+	if err != nil {
+		return KDFParams{}, fmt.Errorf("ошибка генерации соли: %v", err)
+	}
+
+	params := legacyKDFParams(Meta{})
+	params.Salt = fmt.Sprintf("%x", salt)
+	return params, nil
+}
+
+func newKeySlot(slotType, label string, secret []byte, keyFile bool, kdf KDFParams, dataKey []byte, cipherName string) (KeySlot, error) {
+	slotKey, err := GenerateKey(secret, kdf)
+	if err != nil {
+		return KeySlot{}, err
+	}
+	defer wipeBytes(slotKey)
+
+	wrapped, err := Encrypt(dataKey, slotKey, cipherName)
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	return KeySlot{
+		ID:         uuid.NewString(),
+		Type:       slotType,
+		Label:      label,
+		KDF:        kdf,
+		KeyFile:    keyFile,
+		WrappedKey: wrapped,
+	}, nil
+}
+
+func newPasswordSlot(label, masterPassword, keyFile string, kdf KDFParams, dataKey []byte, cipherName string) (KeySlot, error) {
+	secret, err := masterSecret(masterPassword, keyFile)
+	if err != nil {
+		return KeySlot{}, err
+	}
+	return newKeySlot(SlotPassword, label, secret, keyFile != "", kdf, dataKey, cipherName)
+}
+
+func newRecoverySlot(label string, dataKey []byte, cipherName string) (KeySlot, string, error) {
+	code, secret, err := NewRecoveryCode()
+	if err != nil {
+		return KeySlot{}, "", err
+	}
+
+	kdf, err := recoveryKDFParams()
+	if err != nil {
+		return KeySlot{}, "", err
+	}
+
+	slot, err := newKeySlot(SlotRecovery, label, secret, false, kdf, dataKey, cipherName)
+	return slot, code, err
+}
+
+// Returns the data key when secret opens the slot, AEAD authentication rejects any other secret
+func (slot KeySlot) unwrap(secret []byte) ([]byte, bool, error) {
+	slotKey, err := GenerateKey(secret, slot.KDF)
+	if err != nil {
+		return nil, false, err
+	}
+	defer wipeBytes(slotKey)
+
+	dataKey, err := Decrypt(slot.WrappedKey, slotKey)
+	if err != nil {
+		return nil, false, nil
+	}
+	return dataKey, true, nil
+}
+
+// Tries secret against every slot that can take it: recovery slots when it parses as a recovery code,
+// then password slots. Returns the data key and the id of the slot that opened it
+func UnlockPasswordFile(filename, secret, keyFile string) (isOk bool, key []byte, slotID string, err error) {
+	passwordFile, err := loadPasswordFile(filename)
+	if err != nil {
+		return false, nil, "", err
+	}
+
+	if len(passwordFile.Header.Slots) == 0 {
+		return unlockLegacyPasswordFile(filename, passwordFile, secret, keyFile)
+	}
+
+	if recoverySecret, err := ParseRecoveryCode(secret); err == nil {
+		for _, slot := range passwordFile.Header.Slots {
+			if slot.Type != SlotRecovery {
+				continue
+			}
+			dataKey, ok, err := slot.unwrap(recoverySecret)
+			if err != nil {
+				return false, nil, "", err
+			}
+			if ok {
+				return verifiedUnlock(filename, dataKey, slot.ID)
+			}
+		}
+	}
+
+	for _, slot := range passwordFile.Header.Slots {
+		if slot.Type != SlotPassword || (slot.KeyFile && keyFile == "") {
+			continue
+		}
+
+		slotKeyFile := keyFile
+		if !slot.KeyFile {
+			slotKeyFile = ""
+		}
+
+		passwordSecret, err := masterSecret(secret, slotKeyFile)
+		if err != nil {
+			return false, nil, "", err
+		}
+
+		dataKey, ok, err := slot.unwrap(passwordSecret)
+		if err != nil {
+			return false, nil, "", err
+		}
+		if ok {
+			return verifiedUnlock(filename, dataKey, slot.ID)
+		}
+	}
+
+	return false, nil, "", nil
+}
+
+// A slot only covers the key, the rest of the file has to pass its MAC before the unlock counts
+func verifiedUnlock(filename string, key []byte, slotID string) (bool, []byte, string, error) {
+	if _, _, err := openPasswordFile(filename, key); err != nil {
+		wipeBytes(key)
+		return false, nil, "", err
+	}
+	return true, key, slotID, nil
+}
+
+// Files without key slots derive the data key straight from the master password.
+// On unlock they get a random data key wrapped by a password slot with the same parameters,
+// unless another instance holds the vault lock
+func unlockLegacyPasswordFile(filename string, passwordFile *PasswordFile, masterPassword, keyFile string) (bool, []byte, string, error) {
+	isOk, legacyKey, err := checkLegacyPassword(passwordFile, masterPassword, keyFile)
+	if err != nil || !isOk {
+		return false, nil, "", err
+	}
+	defer wipeBytes(legacyKey)
+
+	_, db, err := openPasswordFile(filename, legacyKey)
+	if err != nil {
+		return false, nil, "", err
+	}
+
+	// Keeps the name the legacy key gave the vault, so an old legacy copy swapped in
+	// after the upgrade is caught instead of being upgraded under a new one
+	passwordFile.Header.VaultID = passwordFile.vaultID(legacyKey)
+	seen, err := seenRevision(passwordFile.Header.VaultID)
+	if err != nil {
+		return false, nil, "", fmt.Errorf("failed to read seen revisions: %v", err)
+	}
+	if passwordFile.Header.Revision < seen {
+		return false, nil, "", &RollbackError{Revision: passwordFile.Header.Revision, Seen: seen}
+	}
+
+	// The upgrade writes the file, so it waits until no other instance has the vault open.
+	// Until then the legacy key opens it in memory
+	lock, err := AcquireVaultLock(filename)
+	if errors.Is(err, ErrVaultLocked) {
+		return true, append([]byte{}, legacyKey...), "", nil
+	}
+	if err != nil {
+		return false, nil, "", err
+	}
+	defer lock.Release()
+
+	dataKey, err := GenerateDataKey()
+	if err != nil {
+		return false, nil, "", err
+	}
+
+	if IsLegacyCipher(passwordFile.Header.Crypto.Cipher) {
+		passwordFile.Header.Crypto.Cipher = DefaultCipher
+	}
+
+	wrapped, err := Encrypt(dataKey, legacyKey, passwordFile.Header.Crypto.Cipher)
+	if err != nil {
+		return false, nil, "", err
+	}
+
+	slot := KeySlot{
+		ID:         uuid.NewString(),
+		Type:       SlotPassword,
+		Label:      "master",
+		KDF:        kdfParams(passwordFile),
+		KeyFile:    passwordFile.Header.KeyFile,
+		WrappedKey: wrapped,
+	}
+
+	passwordFile.Header.Slots = []KeySlot{slot}
+	passwordFile.Header.KDF = nil
+	passwordFile.Header.KeyFile = false
+	passwordFile.Header.Verifier = ""
+
+	if err := saveVault(filename, passwordFile, db, dataKey); err != nil {
+		return false, nil, "", err
+	}
+
+	return true, dataKey, slot.ID, nil
+}
+
+// Loads the vault, lets update change its header and writes it back atomically
+func updateKeySlots(path string, key []byte, update func(header *Header) error) error {
+	return updateVault(path, key, func(passwordFile *PasswordFile, _ *Database) error {
+		return update(&passwordFile.Header)
+	})
+}
+
+func AddPasswordSlot(path string, key []byte, label, password, keyFile string, kdf KDFParams) error {
+	return updateKeySlots(path, key, func(header *Header) error {
+		slot, err := newPasswordSlot(label, password, keyFile, kdf, key, header.Crypto.Cipher)
+		if err != nil {
+			return err
+		}
+		header.Slots = append(header.Slots, slot)
+		return nil
+	})
+}
+
+// Adds a recovery slot and returns its code, which is not stored anywhere
+func AddRecoverySlot(path string, key []byte, label string) (string, error) {
+	var code string
+	err := updateKeySlots(path, key, func(header *Header) error {
+		slot, slotCode, err := newRecoverySlot(label, key, header.Crypto.Cipher)
+		if err != nil {
+			return err
+		}
+		header.Slots = append(header.Slots, slot)
+		code = slotCode
+		return nil
+	})
+	return code, err
+}
+
+// Removes a password or recovery slot. sessionSlotID is the slot the vault was opened with,
+// it stays, and so does the last password slot
+func RemoveSlot(path string, key []byte, slotID, sessionSlotID string) error {
+	if slotID == sessionSlotID {
+		return fmt.Errorf("cannot remove the key slot the vault was opened with")
+	}
+
+	return updateKeySlots(path, key, func(header *Header) error {
+		if len(header.Slots) <= 1 {
+			return fmt.Errorf("cannot remove the last key slot")
+		}
+
+		passwords := 0
+		for _, slot := range header.Slots {
+			if slot.Type == SlotPassword {
+				passwords++
+			}
+		}
+
+		for i, slot := range header.Slots {
+			if slot.ID == slotID {
+				// Removing a member without re-keying would leave them the data key
+				if slot.Type == SlotMember {
+					return fmt.Errorf("remove team members from the Team members screen")
+				}
+				if slot.Type == SlotPassword && passwords <= 1 {
+					return fmt.Errorf("cannot remove the last password slot")
+				}
+				header.Slots = append(header.Slots[:i], header.Slots[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("key slot not found")
+	})
+}
+
+// Result of re-keying a vault
+type RekeyResult struct {
+	Key []byte
+	// Code of the recovery slot that replaces the dropped ones, empty when there were none
+	RecoveryCode string
+	// Password and recovery slots that could not be rewrapped
+	Dropped int
+}
+
+// Rewraps one slot for the new data key, false drops it
+type slotRewrap func(slot KeySlot, newKey []byte, cipherName string) (KeySlot, bool, error)
+
+// Moves the vault to a new random data key and encrypts the payload with it, so the old key and
+// the old slots, e.g. in backups, do not open the current file. The old key is kept in the payload
+// to restore those backups. Every slot goes through rewrap, add may append new ones. Dropped
+// recovery slots are replaced by a single new one, recovery shares of the old key stop working
+func rekeyVault(path string, key []byte, rewrap slotRewrap, add func(newKey []byte, cipherName string) (KeySlot, error)) (RekeyResult, error) {
+	passwordFile, db, err := openPasswordFile(path, key)
+	if err != nil {
+		return RekeyResult{}, err
+	}
+
+	newKey, err := GenerateDataKey()
+	if err != nil {
+		return RekeyResult{}, err
+	}
+
+	result, err := rekeySlots(passwordFile, rewrap, add, newKey)
+	if err != nil {
+		wipeBytes(newKey)
+		return RekeyResult{}, err
+	}
+
+	// Named by the old key until now, the revisions seen under it still apply
+	passwordFile.Header.VaultID = passwordFile.vaultID(key)
+	passwordFile.Header.Verifier = ""
+
+	// Backups taken so far only open with the old key, shares of it stop working
+	db.PreviousKeys = append([]string{hex.EncodeToString(key)}, db.PreviousKeys...)
+	db.Meta.RecoveryShares = 0
+
+	if err := saveVault(path, passwordFile, db, newKey); err != nil {
+		wipeBytes(newKey)
+		return RekeyResult{}, err
+	}
+	return result, nil
+}
+
+func rekeySlots(passwordFile *PasswordFile, rewrap slotRewrap, add func(newKey []byte, cipherName string) (KeySlot, error), newKey []byte) (RekeyResult, error) {
+	result := RekeyResult{Key: newKey}
+	cipherName := passwordFile.Header.Crypto.Cipher
+	droppedRecovery := false
+	var slots []KeySlot
+
+	for _, slot := range passwordFile.Header.Slots {
+		rewrapped, ok, err := rewrap(slot, newKey, cipherName)
+		if err != nil {
+			return RekeyResult{}, err
+		}
+		if ok {
+			slots = append(slots, rewrapped)
+			continue
+		}
+
+		switch slot.Type {
+		case SlotPassword:
+			result.Dropped++
+		case SlotRecovery:
+			result.Dropped++
+			droppedRecovery = true
+		}
+	}
+
+	if add != nil {
+		slot, err := add(newKey, cipherName)
+		if err != nil {
+			return RekeyResult{}, err
+		}
+		slots = append(slots, slot)
+	}
+
+	if len(slots) == 0 {
+		return RekeyResult{}, fmt.Errorf("no key slot would be left, enter a master password to keep")
+	}
+
+	if droppedRecovery {
+		slot, code, err := newRecoverySlot("recovery", newKey, cipherName)
+		if err != nil {
+			return RekeyResult{}, err
+		}
+		slots = append(slots, slot)
+		result.RecoveryCode = code
+	}
+
+	passwordFile.Header.Slots = slots
+	return result, nil
+}
+
+// What re-keying the vault for a password change drops
+type RekeyLoss struct {
+	// Password slots other than the one that is changed
+	Passwords int
+	Recovery  int
+	// Sets of recovery shares of the current key
+	ShareSets int
+}
+
+func (loss RekeyLoss) Any() bool {
+	return loss.Passwords > 0 || loss.Recovery > 0 || loss.ShareSets > 0
+}
+
+// What ChangeMasterPassword on slotID would drop, or ResetMasterPassword when slotID is empty
+func PasswordChangeLoss(path string, key []byte, slotID string) (RekeyLoss, error) {
+	passwordFile, db, err := openPasswordFile(path, key)
+	if err != nil {
+		return RekeyLoss{}, err
+	}
+
+	loss := RekeyLoss{ShareSets: db.Meta.RecoveryShares}
+	for _, slot := range passwordFile.Header.Slots {
+		switch {
+		case slot.Type == SlotPassword && slot.ID != slotID:
+			loss.Passwords++
+		case slot.Type == SlotRecovery:
+			loss.Recovery++
+		}
+	}
+	return loss, nil
+}
+
+// Sets a new master password in the password slot slotID and re-keys the vault, so the old password
+// does not open it even with a backup. Members are rewrapped, other password slots are dropped
+func ChangeMasterPassword(path string, key []byte, slotID, newPassword, keyFile string, kdf KDFParams) (RekeyResult, error) {
+	header, err := ReadPasswordFileHeader(path)
+	if err != nil {
+		return RekeyResult{}, err
+	}
+
+	index, err := header.masterSlot(slotID)
+	if err != nil {
+		return RekeyResult{}, err
+	}
+	if header.Slots[index].KeyFile && keyFile == "" {
+		return RekeyResult{}, fmt.Errorf("key slot requires a key file")
+	}
+
+	return rekeyVault(path, key, func(slot KeySlot, newKey []byte, cipherName string) (KeySlot, bool, error) {
+		switch {
+		case slot.Type == SlotMember:
+			return rewrapMember(slot, newKey)
+		case slot.ID != slotID || slot.Type != SlotPassword:
+			return KeySlot{}, false, nil
+		}
+
+		slotKeyFile := keyFile
+		if !slot.KeyFile {
+			slotKeyFile = ""
+		}
+		rewrapped, err := newPasswordSlot(slot.Label, newPassword, slotKeyFile, kdf, newKey, cipherName)
+		rewrapped.ID = slot.ID
+		return rewrapped, err == nil, err
+	}, nil)
+}
+
+// Re-keys a vault opened with recovery shares, which stand in for a lost master password,
+// under a new master password slot. Members are rewrapped, other password slots are dropped
+func ResetMasterPassword(path string, key []byte, newPassword, keyFile string, kdf KDFParams) (RekeyResult, string, error) {
+	var slotID string
+	result, err := rekeyVault(path, key, func(slot KeySlot, newKey []byte, _ string) (KeySlot, bool, error) {
+		if slot.Type == SlotMember {
+			return rewrapMember(slot, newKey)
+		}
+		return KeySlot{}, false, nil
+	}, func(newKey []byte, cipherName string) (KeySlot, error) {
+		slot, err := newPasswordSlot("master", newPassword, keyFile, kdf, newKey, cipherName)
+		slotID = slot.ID
+		return slot, err
+	})
+	return result, slotID, err
+}
+
+// Splits the data key of an open vault into printable shares, any threshold of them unlock it.
+// The vault counts the sets, so a password change can warn that they stop working
+func GenerateRecoveryShares(path string, key []byte, n, threshold int) ([]string, error) {
+	shares, err := SplitSecret(key, n, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	err = updateVault(path, key, func(_ *PasswordFile, db *Database) error {
+		db.Meta.RecoveryShares++
+		return nil
+	})
+	if err != nil {
+		for _, share := range shares {
+			wipeBytes(share.Y)
+		}
+		return nil, err
+	}
+
+	printed := make([]string, len(shares))
+	for i, share := range shares {
+		printed[i] = share.String()
+		wipeBytes(share.Y)
+	}
+	return printed, nil
+}
+
+// Reconstructs the data key from printed shares and checks it with the vault verifier
+func UnlockWithShares(filename string, printed []string) (isOk bool, key []byte, err error) {
+	header, err := ReadPasswordFileHeader(filename)
+	if err != nil {
+		return false, nil, err
+	}
+	if header.Verifier == "" {
+		return false, nil, fmt.Errorf("file has no key verifier")
+	}
+
+	shares := make([]Share, len(printed))
+	for i, text := range printed {
+		share, err := ParseShare(text)
+		if err != nil {
+			return false, nil, err
+		}
+		shares[i] = share
+	}
+
+	key, err = CombineShares(shares)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if !header.CheckKey(key) {
+		wipeBytes(key)
+		return false, nil, nil
+	}
+
+	isOk, key, _, err = verifiedUnlock(filename, key, "")
+	return isOk, key, err
+}
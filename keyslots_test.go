@@ -0,0 +1,183 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestChangeMasterPassword(t *testing.T) {
+	path, key := newTestVault(t)
+
+	member, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddMember(path, key, "member", member.Recipient().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := ReadPasswordFileHeader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slotIDs := map[string]string{}
+	for _, slot := range header.Slots {
+		slotIDs[slot.Type] = slot.ID
+	}
+
+	kdf, err := recoveryKDFParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the password slot the vault was opened with can be changed
+	for _, slotType := range []string{SlotRecovery, SlotMember} {
+		if _, err := ChangeMasterPassword(path, key, slotIDs[slotType], "changed", "", kdf); err == nil {
+			t.Errorf("changed the master password through the %s slot", slotType)
+		}
+	}
+
+	if err := AddPasswordSlot(path, key, "laptop", "other", "", kdf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateRecoveryShares(path, key, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// The form lists what the change drops before it is confirmed
+	loss, err := PasswordChangeLoss(path, key, slotIDs[SlotPassword])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (RekeyLoss{Passwords: 1, Recovery: 1, ShareSets: 1}); loss != want {
+		t.Errorf("loss %+v, want %+v", loss, want)
+	}
+
+	// A backup taken before the change still opens with the old password
+	backup := path + ".bak"
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(backup, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ChangeMasterPassword(path, key, slotIDs[SlotPassword], "changed", "", kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RecoveryCode == "" {
+		t.Error("no new recovery code for the dropped recovery slot")
+	}
+
+	isOk, oldKey, _, err := UnlockPasswordFile(backup, "test", "")
+	if err != nil || !isOk {
+		t.Fatalf("backup unlock: ok %v, %v", isOk, err)
+	}
+	if _, _, err := openPasswordFile(path, oldKey); err == nil {
+		t.Error("the key of the backup opens the vault after the password change")
+	}
+
+	for _, password := range []string{"test", "other"} {
+		if isOk, _, _, _ := UnlockPasswordFile(path, password, ""); isOk {
+			t.Errorf("the dropped password %q opens the vault", password)
+		}
+	}
+
+	loss, err = PasswordChangeLoss(path, result.Key, slotIDs[SlotPassword])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (RekeyLoss{Recovery: 1}); loss != want {
+		t.Errorf("loss after the change %+v, want %+v", loss, want)
+	}
+
+	tests := []struct {
+		name   string
+		unlock func() (bool, []byte, string, error)
+	}{
+		{"new password", func() (bool, []byte, string, error) {
+			return UnlockPasswordFile(path, "changed", "")
+		}},
+		{"new recovery code", func() (bool, []byte, string, error) {
+			return UnlockPasswordFile(path, result.RecoveryCode, "")
+		}},
+		{"member", func() (bool, []byte, string, error) {
+			return UnlockWithIdentity(path, member)
+		}},
+	}
+	for _, tt := range tests {
+		isOk, newKey, _, err := tt.unlock()
+		if err != nil || !isOk {
+			t.Errorf("%s: ok %v, %v", tt.name, isOk, err)
+			continue
+		}
+		entries, err := ReadPasswordFile(path, newKey)
+		if err != nil || len(entries) != 1 {
+			t.Errorf("%s: %d entries, %v", tt.name, len(entries), err)
+		}
+	}
+}
+
+func TestRemoveSlot(t *testing.T) {
+	kdf, err := recoveryKDFParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// Type of the removed slot and of the slot the session was opened with
+		remove, session string
+		// Adds a second password slot first
+		second bool
+		ok     bool
+	}{
+		{"recovery", SlotRecovery, SlotPassword, false, true},
+		{"the session slot", SlotPassword, SlotPassword, true, false},
+		{"the last password slot", SlotPassword, SlotRecovery, false, false},
+		{"another password slot", SlotPassword, SlotRecovery, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, key := newTestVault(t)
+			if tt.second {
+				if err := AddPasswordSlot(path, key, "laptop", "other", "", kdf); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			header, err := ReadPasswordFileHeader(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			slotIDs := map[string]string{}
+			for _, slot := range header.Slots {
+				if _, ok := slotIDs[slot.Type]; !ok {
+					slotIDs[slot.Type] = slot.ID
+				}
+			}
+
+			err = RemoveSlot(path, key, slotIDs[tt.remove], slotIDs[tt.session])
+			if (err == nil) != tt.ok {
+				t.Fatalf("remove: %v, want ok %v", err, tt.ok)
+			}
+
+			after, err := ReadPasswordFileHeader(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := len(header.Slots)
+			if tt.ok {
+				want--
+			}
+			if len(after.Slots) != want {
+				t.Errorf("%d slots, want %d", len(after.Slots), want)
+			}
+		})
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/table"
@@ -79,6 +81,24 @@ var (
 				MarginTop(1).
 				MarginBottom(1)
 
+	statusMessageStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("42")).
+				MarginTop(1).
+				MarginBottom(1)
+
+	diffOldStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	diffNewStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+
+	strengthStyles = []lipgloss.Style{
+		lipgloss.NewStyle().Foreground(lipgloss.Color("196")),
+		lipgloss.NewStyle().Foreground(lipgloss.Color("208")),
+		lipgloss.NewStyle().Foreground(lipgloss.Color("220")),
+		lipgloss.NewStyle().Foreground(lipgloss.Color("112")),
+		lipgloss.NewStyle().Foreground(lipgloss.Color("42")),
+	}
+
+	strengthNames = []string{"very weak", "weak", "fair", "strong", "very strong"}
+
 	buttonStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color("240")).
 			Padding(0, 1)
@@ -90,40 +110,10 @@ var (
 	defaultColumns = []table.Column{
 		{Title: "Title", Width: 30},
 		{Title: "Password", Width: 30},
+		{Title: "2FA", Width: 10},
 	}
 )
 
-// Global store struct
-type Store struct {
-	data map[string]interface{}
-	mu   sync.RWMutex
-}
-
-// Global instance
-var GlobalStore = &Store{
-	data: make(map[string]interface{}),
-}
-
-// Methods
-func (s *Store) Set(key string, value interface{}) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.data[key] = value
-}
-
-func (s *Store) Get(key string) (interface{}, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	value, exists := s.data[key]
-	return value, exists
-}
-
-func (s *Store) Delete(key string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.data, key)
-}
-
 type item string
 
 func (i item) FilterValue() string { return "" }
@@ -153,6 +143,9 @@ func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list
 
 type state int
 
+// Buttons under the record table
+var dbViewButtons = []string{"Add", "Edit", "History", "Delete"}
+
 const (
 	stateMainMenu state = iota
 	stateFileList
@@ -161,6 +154,25 @@ const (
 	stateAddDbForm
 	stateDbView
 	stateAddRecordForm
+	stateManageMenu
+	stateChangePasswordForm
+	stateKeySlots
+	stateAddSlotForm
+	stateRecoveryCode
+	stateSharesForm
+	stateShares
+	stateShareInput
+	stateExportForm
+	stateImportForm
+	stateMembers
+	stateAddMemberForm
+	stateRemoveMemberForm
+	stateShareEntryForm
+	stateImportBundleForm
+	stateBackups
+	stateEditRecordForm
+	stateHistory
+	stateHistoryDepthForm
 	stateError
 )
 
@@ -168,23 +180,81 @@ type model struct {
 	state                state
 	list                 list.Model
 	fileList             list.Model
+	manageList           list.Model
+	slotList             list.Model
+	backupList           list.Model
+	historyList          list.Model
 	passwordInput        textinput.Model
 	titleInput           textinput.Model
+	keyFileInput         textinput.Model
 	dbTitleInput         textinput.Model
 	dbPasswordInput      textinput.Model
+	dbOTPInput           textinput.Model
+	dbUsernameInput      textinput.Model
+	dbURLInput           textinput.Model
+	historyDepthInput    textinput.Model
+	newPasswordInput     textinput.Model
+	confirmPasswordInput textinput.Model
+	slotLabelInput       textinput.Model
+	shareCountInput      textinput.Model
+	shareThresholdInput  textinput.Model
+	shareInput           textinput.Model
+	agePathInput         textinput.Model
+	ageKeysInput         textinput.Model
+	agePassphraseInput   textinput.Model
 	choice               string
 	fileChoice           string
+	vaultPath            string
+	manageMode           bool
+	keyFileRequired      bool
+	keyFile              string
+	slotID               string
+	slotIDs              []string
+	backups              []Backup
+	recoveryCode         string
+	recoveryReturnState  state
+	shares               []string
+	collectedShares      []string
+	forcePasswordChange  bool
+	rekeyLoss            RekeyLoss
+	rekeyConfirmed       bool
+	importNew            bool
+	memberVault          bool
+	memberID             string
+	localRecipient       string
 	quitting             bool
 	width                int
 	height               int
 	titleInputError      bool
 	passwordInputError   bool
+	keyFileInputError    bool
 	dbTitleInputError    bool
 	dbPasswordInputError bool
+	dbOTPInputError      bool
+	historyDepthError    bool
+	newPasswordError     bool
+	confirmPasswordError bool
+	slotLabelError       bool
+	shareCountError      bool
+	shareThresholdError  bool
+	shareInputError      bool
+	agePathError         bool
+	ageKeysError         bool
+	agePassphraseError   bool
 	table                table.Model
 	dbData               []table.Row
+	entries              []Entry
+	breached             map[string]int
+	breachWarnedPassword string
+	strengthPassword     string
+	strength             StrengthResult
+	rollbackWarning      string
+	vaultLock            *VaultLock
+	readOnly             bool
+	otpTick              int
 	activeButton         int
 	errorMessage         string
+	statusMessage        string
 }
 
 // Create styled table
@@ -209,15 +279,70 @@ func createStyledTable(columns []table.Column, rows []table.Row) table.Model {
 	return t
 }
 
+// Refreshes one-time codes while a vault is open
+type otpTickMsg struct {
+	id int
+}
+
+func otpTick(id int) tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return otpTickMsg{id: id}
+	})
+}
+
+// Current one-time code of an entry for the table
+func otpCell(entry Entry, now time.Time) string {
+	if entry.OTP == "" {
+		return ""
+	}
+
+	otp, err := ParseOTP(entry.OTP)
+	if err != nil {
+		return "invalid"
+	}
+	code, _ := otp.Code(now)
+	return code
+}
+
 // Update table data
 func (m *model) updateTable() {
+	now := time.Now()
+	m.dbData = make([]table.Row, len(m.entries))
+	for i, entry := range m.entries {
+		title := entry.Title
+		if m.breached[entry.ID] > 0 {
+			title = "! " + title
+		}
+		m.dbData[i] = table.Row{title, entry.Password, otpCell(entry, now)}
+	}
 	m.table.SetRows(m.dbData)
 }
 
+// Reload the entries of the open vault
+func (m *model) reloadEntries(key []byte) error {
+	entries, err := ReadPasswordFile(m.vaultPath, key)
+
+	// A rolled back vault still opens, the warning stays until a save moves it past the seen revision
+	var rollback *RollbackError
+	m.rollbackWarning = ""
+	if errors.As(err, &rollback) {
+		m.rollbackWarning = "WARNING: " + rollback.Error()
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m.entries = entries
+	m.updateTable()
+	return nil
+}
+
 // Initialize model with dynamic list height
 func initialModel() model {
 	items := []list.Item{
 		item("Add db"),
+		item("Import db"),
 		item("Open db"),
 		item("Manage dbs"),
 		item("Key bindings"),
@@ -277,6 +402,141 @@ func createFileList() (list.Model, error) {
 	return fileList, nil
 }
 
+// Create manage dbs action list
+func createManageList() list.Model {
+	items := []list.Item{
+		item("Change master password"),
+		item("Key slots"),
+		item("Recovery shares"),
+		item("Export (age)"),
+		item("Import (age)"),
+		item("Team members"),
+		item("Restore from backup"),
+		item("History depth"),
+	}
+
+	listHeight := len(items) + 8
+	const defaultWidth = 30
+
+	l := list.New(items, itemDelegate{}, defaultWidth, listHeight)
+	l.Title = "Manage db"
+	l.SetShowStatusBar(false)
+	l.SetFilteringEnabled(false)
+	l.Styles.Title = titleStyle
+	l.Styles.PaginationStyle = paginationStyle
+	l.Styles.HelpStyle = helpStyle
+	return l
+}
+
+// Create key slot list from the vault header
+func createSlotList(header Header) (list.Model, []string) {
+	var items []list.Item
+	var ids []string
+
+	for _, slot := range header.Slots {
+		label := fmt.Sprintf("%s (%s)", slot.Label, slot.Type)
+		if slot.KeyFile {
+			label += " + key file"
+		}
+		items = append(items, item(label))
+		ids = append(ids, slot.ID)
+	}
+
+	listHeight := min(len(items)+8, 15)
+	const defaultWidth = 30
+
+	l := list.New(items, itemDelegate{}, defaultWidth, listHeight)
+	l.Title = "Key slots"
+	l.SetShowStatusBar(false)
+	l.SetFilteringEnabled(false)
+	l.Styles.Title = titleStyle
+	l.Styles.PaginationStyle = paginationStyle
+	l.Styles.HelpStyle = helpStyle
+	return l, ids
+}
+
+// Create team member list from the vault header
+func createMemberList(header Header) (list.Model, []string) {
+	var items []list.Item
+	var ids []string
+
+	for _, slot := range header.Slots {
+		if slot.Type != SlotMember {
+			continue
+		}
+		recipient := slot.Recipient
+		if len(recipient) > 16 {
+			recipient = recipient[:10] + "…" + recipient[len(recipient)-6:]
+		}
+		items = append(items, item(fmt.Sprintf("%s %s", slot.Label, recipient)))
+		ids = append(ids, slot.ID)
+	}
+
+	listHeight := min(len(items)+8, 15)
+	const defaultWidth = 30
+
+	l := list.New(items, itemDelegate{}, defaultWidth, listHeight)
+	l.Title = "Team members"
+	l.SetShowStatusBar(false)
+	l.SetFilteringEnabled(false)
+	l.Styles.Title = titleStyle
+	l.Styles.PaginationStyle = paginationStyle
+	l.Styles.HelpStyle = helpStyle
+	return l, ids
+}
+
+// Create backup list with the record count of each backup, "?" when none of the vault keys opens it
+func createBackupList(path string, backups []Backup, key []byte) list.Model {
+	var items []list.Item
+
+	for _, backup := range backups {
+		count := "?"
+		if n, err := BackupEntryCount(path, backup, key); err == nil {
+			count = strconv.Itoa(n)
+		}
+		items = append(items, item(fmt.Sprintf("%s  %s records", backup.Time.Local().Format("2006-01-02 15:04:05"), count)))
+	}
+
+	listHeight := min(len(items)+8, 15)
+	const defaultWidth = 40
+
+	l := list.New(items, itemDelegate{}, defaultWidth, listHeight)
+	l.Title = "Backups"
+	l.SetShowStatusBar(false)
+	l.SetFilteringEnabled(false)
+	l.Styles.Title = titleStyle
+	l.Styles.PaginationStyle = paginationStyle
+	l.Styles.HelpStyle = helpStyle
+	return l
+}
+
+// Create list of previous versions of a record, each named by the fields it differs in from the next one
+func createHistoryList(entry Entry) list.Model {
+	var items []list.Item
+
+	newer := entry.version(time.Now())
+	for _, version := range entry.History {
+		fields := strings.Join(changedFields(version, newer), ", ")
+		if fields == "" {
+			fields = "no changes"
+		}
+		items = append(items, item(fmt.Sprintf("%s  %s", version.Changed.Local().Format("2006-01-02 15:04"), fields)))
+		newer = version
+	}
+
+	listHeight := min(len(items)+8, 15)
+	const defaultWidth = 40
+
+	l := list.New(items, itemDelegate{}, defaultWidth, listHeight)
+	l.Title = "History of " + entry.Title
+	l.SetShowStatusBar(false)
+	l.SetFilteringEnabled(false)
+	l.Styles.Title = titleStyle
+	l.Styles.PaginationStyle = paginationStyle
+	l.Styles.HelpStyle = helpStyle
+	return l
+}
+
 // Create password input field
 func createPasswordInput() textinput.Model {
 	input := textinput.New()
@@ -299,6 +559,71 @@ func createTitleInput() textinput.Model {
 	return input
 }
 
+// Create key file path input field
+func createKeyFileInput() textinput.Model {
+	input := textinput.New()
+	input.Placeholder = "Path to key file"
+	input.CharLimit = 4096
+	input.Width = 30
+	return input
+}
+
+// Create numeric input field
+func createNumberInput(placeholder string) textinput.Model {
+	input := textinput.New()
+	input.Placeholder = placeholder
+	input.CharLimit = 3
+	input.Width = 30
+	input.Validate = func(s string) error {
+		if _, err := strconv.Atoi(s); s != "" && err != nil {
+			return err
+		}
+		return nil
+	}
+	return input
+}
+
+// Create age file path input field
+func createAgePathInput(placeholder string) textinput.Model {
+	input := textinput.New()
+	input.Placeholder = placeholder
+	input.Focus()
+	input.CharLimit = 256
+	input.Width = 45
+	return input
+}
+
+// Create age recipients or identity file input field
+func createAgeKeysInput(placeholder string) textinput.Model {
+	input := textinput.New()
+	input.Placeholder = placeholder
+	input.CharLimit = 2048
+	input.Width = 45
+	return input
+}
+
+// Create recovery share input field
+func createShareInput() textinput.Model {
+	input := textinput.New()
+	input.Placeholder = "Enter recovery share"
+	input.Focus()
+	input.CharLimit = 200
+	input.Width = 60
+	return input
+}
+
+// Move focus to the next input, wrapping around
+func focusNextInput(inputs ...*textinput.Model) {
+	for i, input := range inputs {
+		if input.Focused() {
+			input.Blur()
+			inputs[(i+1)%len(inputs)].Focus()
+			return
+		}
+	}
+	inputs[0].Focus()
+}
+
 // Create DB record title input field
 func createDbTitleInput() textinput.Model {
 	input := textinput.New()
@@ -309,6 +634,33 @@ func createDbTitleInput() textinput.Model {
 	return input
 }
 
+// Create DB record username input field
+func createDbUsernameInput() textinput.Model {
+	input := textinput.New()
+	input.Placeholder = "Username (optional)"
+	input.CharLimit = 256
+	input.Width = 30
+	return input
+}
+
+// Create DB record URL input field
+func createDbURLInput() textinput.Model {
+	input := textinput.New()
+	input.Placeholder = "URL (optional)"
+	input.CharLimit = 512
+	input.Width = 45
+	return input
+}
+
+// Create DB record one-time password input field
+func createDbOTPInput() textinput.Model {
+	input := textinput.New()
+	input.Placeholder = "otpauth:// URI or base32 secret (optional)"
+	input.CharLimit = 512
+	input.Width = 45
+	return input
+}
+
 // Create DB record password input field
 func createDbPasswordInput() textinput.Model {
 	input := textinput.New()
@@ -327,12 +679,18 @@ func (m *model) handleGlobalKeys(keypress string) (tea.Model, tea.Cmd) {
 		return nil, nil
 	}
 
-	if m.state == stateAddDbForm || m.state == statePasswordInput || m.state == stateAddRecordForm {
+	if m.state == stateAddDbForm || m.state == statePasswordInput || m.state == stateAddRecordForm ||
+		m.state == stateChangePasswordForm || m.state == stateAddSlotForm || m.state == stateRecoveryCode ||
+		m.state == stateSharesForm || m.state == stateShares || m.state == stateShareInput ||
+		m.state == stateExportForm || m.state == stateImportForm || m.state == stateAddMemberForm ||
+		m.state == stateRemoveMemberForm || m.state == stateShareEntryForm || m.state == stateImportBundleForm ||
+		m.state == stateEditRecordForm || m.state == stateHistoryDepthForm {
 		return nil, nil
 	}
 
 	switch keypress {
 	case "q", "ctrl+c":
+		m.closeVault()
 		m.quitting = true
 		return m, tea.Quit
 	case "m":
@@ -351,20 +709,65 @@ func (m *model) handleGlobalKeys(keypress string) (tea.Model, tea.Cmd) {
 
 // Reset to main menu
 func (m *model) resetToMainMenu() *model {
+	m.closeVault()
 	m.state = stateMainMenu
 	m.choice = ""
 	m.fileChoice = ""
+	m.vaultPath = ""
+	m.manageMode = false
+	m.keyFileRequired = false
+	m.keyFile = ""
+	m.slotID = ""
+	m.slotIDs = nil
+	m.recoveryCode = ""
+	m.shares = nil
+	m.collectedShares = nil
+	m.forcePasswordChange = false
 	m.passwordInput = textinput.Model{}
 	m.titleInput = textinput.Model{}
+	m.keyFileInput = textinput.Model{}
 	m.dbTitleInput = textinput.Model{}
 	m.dbPasswordInput = textinput.Model{}
+	m.dbOTPInput = textinput.Model{}
+	m.dbUsernameInput = textinput.Model{}
+	m.dbURLInput = textinput.Model{}
+	m.historyDepthInput = textinput.Model{}
+	m.newPasswordInput = textinput.Model{}
+	m.confirmPasswordInput = textinput.Model{}
+	m.slotLabelInput = textinput.Model{}
+	m.shareCountInput = textinput.Model{}
+	m.shareThresholdInput = textinput.Model{}
+	m.shareInput = textinput.Model{}
+	m.agePathInput = textinput.Model{}
+	m.ageKeysInput = textinput.Model{}
+	m.agePassphraseInput = textinput.Model{}
+	m.importNew = false
+	m.memberVault = false
+	m.memberID = ""
+	m.localRecipient = ""
 	m.titleInputError = false
 	m.passwordInputError = false
+	m.keyFileInputError = false
 	m.dbTitleInputError = false
 	m.dbPasswordInputError = false
+	m.dbOTPInputError = false
+	m.historyDepthError = false
+	m.newPasswordError = false
+	m.confirmPasswordError = false
+	m.slotLabelError = false
+	m.shareCountError = false
+	m.shareThresholdError = false
+	m.shareInputError = false
+	m.agePathError = false
+	m.ageKeysError = false
+	m.agePassphraseError = false
 	m.dbData = []table.Row{}
+	m.entries = nil
+	m.breached = nil
+	m.rollbackWarning = ""
 	m.activeButton = 0
 	m.errorMessage = ""
+	m.statusMessage = ""
 	return m
 }
 
@@ -373,25 +776,133 @@ func (m *model) goBack() *model {
 	switch m.state {
 	case stateFileList:
 		m.state = stateMainMenu
+		m.manageMode = false
 	case statePasswordInput:
 		m.state = stateFileList
 		m.fileChoice = ""
+		m.vaultPath = ""
+		m.memberVault = false
 		m.passwordInput = textinput.Model{}
 		m.passwordInputError = false
+		m.keyFileInput = textinput.Model{}
+		m.keyFileInputError = false
+		m.keyFileRequired = false
 	case stateKeyBindings:
 		m.state = stateMainMenu
 	case stateAddDbForm:
 		m.state = stateMainMenu
 	case stateDbView:
+		m.closeVault()
 		m.state = stateFileList
 		m.fileChoice = ""
+		m.vaultPath = ""
+		m.keyFile = ""
+		m.slotID = ""
 		m.dbData = []table.Row{}
-	case stateAddRecordForm:
+		m.entries = nil
+		m.breached = nil
+		m.rollbackWarning = ""
+	case stateAddRecordForm, stateEditRecordForm:
 		m.state = stateDbView
 		m.dbTitleInput = textinput.Model{}
 		m.dbPasswordInput = textinput.Model{}
+		m.dbOTPInput = textinput.Model{}
+		m.dbUsernameInput = textinput.Model{}
+		m.dbURLInput = textinput.Model{}
 		m.dbTitleInputError = false
 		m.dbPasswordInputError = false
+		m.dbOTPInputError = false
+		m.breachWarnedPassword = ""
+	case stateHistory:
+		m.state = stateDbView
+		m.statusMessage = ""
+	case stateHistoryDepthForm:
+		m.state = stateManageMenu
+		m.historyDepthInput = textinput.Model{}
+		m.historyDepthError = false
+	case stateManageMenu:
+		m.closeVault()
+		m.state = stateFileList
+		m.fileChoice = ""
+		m.vaultPath = ""
+		m.keyFile = ""
+		m.slotID = ""
+		m.statusMessage = ""
+		m.rollbackWarning = ""
+	case stateChangePasswordForm:
+		m.state = stateManageMenu
+		m.newPasswordInput = textinput.Model{}
+		m.confirmPasswordInput = textinput.Model{}
+		m.keyFileInput = textinput.Model{}
+		m.keyFileRequired = false
+		m.newPasswordError = false
+		m.confirmPasswordError = false
+		m.keyFileInputError = false
+	case stateSharesForm, stateShares:
+		m.state = stateManageMenu
+		m.shareCountInput = textinput.Model{}
+		m.shareThresholdInput = textinput.Model{}
+		m.shareCountError = false
+		m.shareThresholdError = false
+		m.shares = nil
+	case stateExportForm, stateImportForm:
+		m.state = stateManageMenu
+		m.agePathInput = textinput.Model{}
+		m.ageKeysInput = textinput.Model{}
+		m.agePassphraseInput = textinput.Model{}
+		m.agePathError = false
+		m.ageKeysError = false
+		m.agePassphraseError = false
+	case stateShareEntryForm, stateImportBundleForm:
+		m.state = stateDbView
+		m.agePathInput = textinput.Model{}
+		m.ageKeysInput = textinput.Model{}
+		m.agePassphraseInput = textinput.Model{}
+		m.agePathError = false
+		m.ageKeysError = false
+		m.agePassphraseError = false
+	case stateShareInput:
+		m.state = statePasswordInput
+		m.shareInput = textinput.Model{}
+		m.shareInputError = false
+		m.collectedShares = nil
+		m.statusMessage = ""
+		m.passwordInput.Focus()
+	case stateKeySlots:
+		m.state = stateManageMenu
+		m.slotIDs = nil
+	case stateBackups:
+		m.state = stateManageMenu
+		m.backups = nil
+		m.errorMessage = ""
+	case stateMembers:
+		m.state = stateManageMenu
+		m.slotIDs = nil
+		m.localRecipient = ""
+		m.statusMessage = ""
+	case stateAddMemberForm:
+		m.state = stateMembers
+		m.slotLabelInput = textinput.Model{}
+		m.ageKeysInput = textinput.Model{}
+		m.slotLabelError = false
+		m.ageKeysError = false
+	case stateRemoveMemberForm:
+		m.state = stateMembers
+		m.memberID = ""
+		m.passwordInput = textinput.Model{}
+		m.keyFileInput = textinput.Model{}
+		m.passwordInputError = false
+		m.keyFileInputError = false
+	case stateAddSlotForm:
+		m.state = stateKeySlots
+		m.slotLabelInput = textinput.Model{}
+		m.newPasswordInput = textinput.Model{}
+		m.confirmPasswordInput = textinput.Model{}
+		m.keyFileInput = textinput.Model{}
+		m.slotLabelError = false
+		m.newPasswordError = false
+		m.confirmPasswordError = false
+		m.keyFileInputError = false
 	case stateError:
 		m.state = stateMainMenu
 		m.errorMessage = ""
@@ -406,6 +917,135 @@ func (m *model) setError(message string) {
 	m.errorMessage = message
 }
 
+// Show the form that writes the selected record into a share bundle
+func (m *model) openShareEntryForm() (tea.Model, tea.Cmd) {
+	if m.table.Cursor() >= len(m.entries) {
+		return m, nil
+	}
+
+	m.agePathInput = createAgePathInput("Bundle file path")
+	m.ageKeysInput = createAgeKeysInput("age1... recipients, comma separated")
+	m.agePassphraseInput = createPasswordInput()
+	m.agePassphraseInput.Placeholder = "Or a passphrase"
+	m.agePassphraseInput.Blur()
+	m.agePathError = false
+	m.ageKeysError = false
+	m.agePassphraseError = false
+	m.errorMessage = ""
+	m.statusMessage = ""
+	m.state = stateShareEntryForm
+	return m, nil
+}
+
+// Show the form that adds the record of a share bundle to the open vault
+func (m *model) openImportBundleForm() (tea.Model, tea.Cmd) {
+	if !m.writable() {
+		return m, nil
+	}
+
+	m.agePathInput = createAgePathInput("Bundle file")
+	m.ageKeysInput = createAgeKeysInput("Identity file, empty for your own")
+	m.agePassphraseInput = createPasswordInput()
+	m.agePassphraseInput.Placeholder = "Or the passphrase"
+	m.agePassphraseInput.Blur()
+	m.agePathError = false
+	m.ageKeysError = false
+	m.agePassphraseError = false
+	m.errorMessage = ""
+	m.statusMessage = ""
+	m.state = stateImportBundleForm
+	return m, nil
+}
+
+// Handle Enter in share record form
+func (m *model) handleShareEntryEnter() (tea.Model, tea.Cmd) {
+	m.agePathError = strings.TrimSpace(m.agePathInput.Value()) == ""
+	m.ageKeysError = false
+	m.agePassphraseError = false
+	if m.agePathError {
+		return m, nil
+	}
+
+	selectedIndex := m.table.Cursor()
+	if selectedIndex >= len(m.entries) {
+		return m.goBack(), nil
+	}
+
+	recipients, err := ageRecipients(m.ageKeysInput.Value(), m.agePassphraseInput.Value())
+	if err != nil {
+		m.ageKeysError = true
+		m.agePassphraseError = m.agePassphraseInput.Value() != ""
+		m.errorMessage = err.Error()
+		return m, nil
+	}
+
+	path := strings.TrimSpace(m.agePathInput.Value())
+	entry := m.entries[selectedIndex]
+	if err := WriteEntryBundle(entry, path, recipients); err != nil {
+		m.agePathError = true
+		m.errorMessage = fmt.Sprintf("Failed to write bundle: %v", err)
+		return m, nil
+	}
+
+	m.goBack()
+	m.errorMessage = ""
+	m.statusMessage = fmt.Sprintf("Shared %s to %s", entry.Title, path)
+	return m, nil
+}
+
+// Handle Enter in import bundle form
+func (m *model) handleImportBundleEnter() (tea.Model, tea.Cmd) {
+	m.agePathError = strings.TrimSpace(m.agePathInput.Value()) == ""
+	m.ageKeysError = false
+	m.agePassphraseError = false
+	if m.agePathError {
+		return m, nil
+	}
+
+	config := ReadConfigFile()
+
+	identityFile := m.ageKeysInput.Value()
+	if strings.TrimSpace(identityFile) == "" && m.agePassphraseInput.Value() == "" {
+		identityFile = config.IdentityFile
+	}
+
+	identities, err := ageIdentities(identityFile, m.agePassphraseInput.Value())
+	if err != nil {
+		m.ageKeysError = true
+		m.agePassphraseError = m.agePassphraseInput.Value() != ""
+		m.errorMessage = err.Error()
+		return m, nil
+	}
+
+	entry, err := ReadEntryBundle(strings.TrimSpace(m.agePathInput.Value()), identities)
+	if err != nil {
+		m.agePathError = true
+		m.errorMessage = fmt.Sprintf("Failed to read bundle: %v", err)
+		return m, nil
+	}
+
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	if err := AddToPasswordFile(m.vaultPath, entry, key); err != nil {
+		m.agePathError = true
+		m.errorMessage = fmt.Sprintf("Failed to add record: %v", err)
+		return m, nil
+	}
+
+	if err := m.reloadEntries(key); err != nil {
+		m.setError(fmt.Sprintf("Failed to read file: %v", err))
+		return m, nil
+	}
+
+	m.goBack()
+	m.errorMessage = ""
+	m.statusMessage = fmt.Sprintf("Added %s", entry.Title)
+	return m, nil
+}
+
 // Handle Enter in main menu
 func (m *model) handleMainMenuEnter() (tea.Model, tea.Cmd) {
 	i, ok := m.list.SelectedItem().(item)
@@ -419,16 +1059,22 @@ func (m *model) handleMainMenuEnter() (tea.Model, tea.Cmd) {
 	case "Add db":
 		m.titleInput = createTitleInput()
 		m.passwordInput = createPasswordInput()
+		m.keyFileInput = createKeyFileInput()
+		m.keyFileInput.Placeholder = "Optional, created if missing"
 		m.titleInput.Focus()
 		m.passwordInput.Blur()
+		m.statusMessage = ""
 		m.state = stateAddDbForm
-	case "Open db":
+	case "Import db":
+		return m.openImportForm(true)
+	case "Open db", "Manage dbs":
 		fileList, err := createFileList()
 		if err != nil {
 			m.setError(fmt.Sprintf("Failed to create file list: %v", err))
 			return m, nil
 		}
 		m.fileList = fileList
+		m.manageMode = m.choice == "Manage dbs"
 		m.state = stateFileList
 	case "Key bindings":
 		m.state = stateKeyBindings
@@ -444,224 +1090,1587 @@ func (m *model) handleFileListEnter() (tea.Model, tea.Cmd) {
 		return m, nil
 	}
 
-	m.fileChoice = string(i)
+	model, cmd := m.openPasswordPrompt(string(i))
+	// Team vaults open straight away when the local identity is a member
+	if m.state == statePasswordInput && m.memberVault {
+		return m.handleIdentityUnlock(true)
+	}
+	return model, cmd
+}
+
+// Ask for the master password of filename
+func (m *model) openPasswordPrompt(filename string) (tea.Model, tea.Cmd) {
+	path := VaultPath(ReadConfigFile().DBsFolder, filename)
+
+	header, err := ReadPasswordFileHeader(path)
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to read password file: %v", err))
+		return m, nil
+	}
+
+	m.fileChoice = filename
+	m.vaultPath = path
 	m.passwordInput = createPasswordInput()
+	m.passwordInput.Placeholder = "Password or recovery key"
 	m.passwordInputError = false
+	m.keyFileRequired = header.RequiresKeyFile()
+	m.keyFileInput = createKeyFileInput()
+	m.keyFileInputError = false
+	m.memberVault = header.HasMembers()
+	if m.memberVault {
+		m.passwordInput.Placeholder = "Password, recovery key or empty for your identity"
+	}
 	m.state = statePasswordInput
 
 	return m, nil
 }
 
+// Unlock a team vault with the local identity. Silent attempts leave the password prompt as it is
+func (m *model) handleIdentityUnlock(silent bool) (tea.Model, tea.Cmd) {
+	identity, err := LoadIdentity(ReadConfigFile().IdentityFile)
+	if err != nil {
+		if !silent {
+			m.passwordInputError = true
+			m.errorMessage = fmt.Sprintf("No local identity: %v", err)
+		}
+		return m, nil
+	}
+
+	isOk, key, slotID, err := UnlockWithIdentity(m.vaultPath, identity)
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to unlock with identity: %v", err))
+		return m, nil
+	}
+	if !isOk {
+		if !silent {
+			m.passwordInputError = true
+			m.errorMessage = "Your identity is not a member of this vault"
+		}
+		return m, nil
+	}
+
+	m.slotID = slotID
+	Session.Set(key)
+	wipeBytes(key)
+	return m.openVault()
+}
+
 // Handle Enter in password input
 func (m *model) handlePasswordEnter() (tea.Model, tea.Cmd) {
-	if m.passwordInput.Value() == "" {
-		m.passwordInputError = true
+	keyFile := strings.TrimSpace(m.keyFileInput.Value())
+
+	if m.passwordInput.Value() == "" && m.memberVault {
+		return m.handleIdentityUnlock(false)
+	}
+
+	m.passwordInputError = m.passwordInput.Value() == ""
+	m.keyFileInputError = false
+
+	if m.passwordInputError {
 		return m, nil
 	}
 
-	isOk, salt, err := IsFileHashValid(m.fileChoice, m.passwordInput.Value())
+	// The key file may be left empty when unlocking with a recovery code
+	if keyFile != "" && !fileExists(expandHome(keyFile)) {
+		m.keyFileInputError = true
+		m.errorMessage = "Key file not found"
+		return m, nil
+	}
+
+	isOk, key, slotID, err := UnlockPasswordFile(m.vaultPath, m.passwordInput.Value(), keyFile)
 	if err != nil {
-		m.setError(fmt.Sprintf("Failed to validate file hash: %v", err))
+		m.setError(fmt.Sprintf("Failed to validate master password: %v", err))
 		return m, nil
 	}
 	if !isOk {
 		m.passwordInputError = true
+		m.keyFileInputError = m.keyFileRequired
 		m.errorMessage = "Invalid password"
+		if m.keyFileRequired {
+			m.errorMessage = "Invalid password or key file"
+		}
 		return m, nil
 	}
 
-	key := GenerateKey(m.passwordInput.Value(), salt)
+	m.slotID = slotID
+	if m.keyFileRequired {
+		m.keyFile = keyFile
+	}
 
-	data, err := ReadPasswordFile(m.fileChoice, key)
-	if err != nil {
+	Session.Set(key)
+	wipeBytes(key)
+	return m.openVault()
+}
+
+// Load the vault unlocked by the session key and show it, or its manage menu
+func (m *model) openVault() (tea.Model, tea.Cmd) {
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	if err := m.reloadEntries(key); err != nil {
+		m.closeVault()
 		m.setError(fmt.Sprintf("Failed to read password file: %v", err))
 		return m, nil
 	}
 
-	GlobalStore.Set("key", key)
-	m.dbData = data
-	m.updateTable()
+	if !m.lockVault() {
+		return m, nil
+	}
+
 	m.state = stateDbView
 	m.activeButton = 0
 	m.errorMessage = ""
 
+	if m.manageMode {
+		m.manageList = createManageList()
+		m.statusMessage = ""
+		m.state = stateManageMenu
+	}
+
+	// A new tick chain, ticks of a previous session are ignored
+	m.otpTick++
+	return m, otpTick(m.otpTick)
+}
+
+// Handle Enter in manage db menu
+func (m *model) handleManageMenuEnter() (tea.Model, tea.Cmd) {
+	i, ok := m.manageList.SelectedItem().(item)
+	if !ok {
+		return m, nil
+	}
+
+	m.statusMessage = ""
+
+	switch string(i) {
+	case "Change master password", "Import (age)", "Restore from backup", "History depth", "Recovery shares":
+		// The manage menu only shows status messages
+		if !m.writable() {
+			m.statusMessage = m.errorMessage
+			m.errorMessage = ""
+			return m, nil
+		}
+	}
+
+	switch string(i) {
+	case "Change master password":
+		return m.openChangePassword()
+	case "Key slots":
+		return m.openKeySlots()
+	case "Export (age)":
+		m.agePathInput = createAgePathInput("Export file path")
+		m.ageKeysInput = createAgeKeysInput("age1... recipients, comma separated")
+		m.agePassphraseInput = createPasswordInput()
+		m.agePassphraseInput.Placeholder = "Or a passphrase"
+		m.agePassphraseInput.Blur()
+		m.agePathError = false
+		m.ageKeysError = false
+		m.agePassphraseError = false
+		m.errorMessage = ""
+		m.state = stateExportForm
+	case "Import (age)":
+		return m.openImportForm(false)
+	case "Team members":
+		return m.openMembers()
+	case "Restore from backup":
+		return m.openBackups()
+	case "History depth":
+		return m.openHistoryDepthForm()
+	case "Recovery shares":
+		m.shareCountInput = createNumberInput("Number of shares")
+		m.shareCountInput.Focus()
+		m.shareThresholdInput = createNumberInput("Shares needed to unlock")
+		m.shareCountError = false
+		m.shareThresholdError = false
+		m.errorMessage = ""
+		m.state = stateSharesForm
+	}
+
 	return m, nil
 }
 
-// Handle Enter in add DB form
-func (m *model) handleAddDbFormEnter() (tea.Model, tea.Cmd) {
-	titleEmpty := m.titleInput.Value() == ""
-	passwordEmpty := m.passwordInput.Value() == ""
+// Show age import form, into a new vault or the open one
+func (m *model) openImportForm(newVault bool) (tea.Model, tea.Cmd) {
+	m.importNew = newVault
+	m.agePathInput = createAgePathInput("age file to import")
+	m.ageKeysInput = createAgeKeysInput("Identity file (AGE-SECRET-KEY-...)")
+	m.agePassphraseInput = createPasswordInput()
+	m.agePassphraseInput.Placeholder = "Or the passphrase"
+	m.agePassphraseInput.Blur()
 
-	m.titleInputError = titleEmpty
-	m.passwordInputError = passwordEmpty
+	if newVault {
+		m.titleInput = createTitleInput()
+		m.passwordInput = createPasswordInput()
+		m.passwordInput.Blur()
+		m.agePathInput.Blur()
+		m.titleInputError = false
+		m.passwordInputError = false
+	}
 
-	if titleEmpty || passwordEmpty {
+	m.agePathError = false
+	m.ageKeysError = false
+	m.agePassphraseError = false
+	m.errorMessage = ""
+	m.statusMessage = ""
+	m.state = stateImportForm
+	return m, nil
+}
+
+// Handle Enter in age export form
+func (m *model) handleExportEnter() (tea.Model, tea.Cmd) {
+	m.agePathError = strings.TrimSpace(m.agePathInput.Value()) == ""
+	m.ageKeysError = false
+	m.agePassphraseError = false
+	if m.agePathError {
+		return m, nil
+	}
+
+	recipients, err := ageRecipients(m.ageKeysInput.Value(), m.agePassphraseInput.Value())
+	if err != nil {
+		m.ageKeysError = true
+		m.agePassphraseError = m.agePassphraseInput.Value() != ""
+		m.errorMessage = err.Error()
+		return m, nil
+	}
+
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	count, err := ExportPasswordFile(m.vaultPath, key, strings.TrimSpace(m.agePathInput.Value()), recipients)
+	if err != nil {
+		m.agePathError = true
+		m.errorMessage = fmt.Sprintf("Failed to export: %v", err)
+		return m, nil
+	}
+
+	path := strings.TrimSpace(m.agePathInput.Value())
+	m.goBack()
+	m.errorMessage = ""
+	m.statusMessage = fmt.Sprintf("Exported %d records to %s", count, path)
+	return m, nil
+}
+
+// Handle Enter in age import form
+func (m *model) handleImportEnter() (tea.Model, tea.Cmd) {
+	m.agePathError = strings.TrimSpace(m.agePathInput.Value()) == ""
+	m.ageKeysError = false
+	m.agePassphraseError = false
+	if m.importNew {
+		m.titleInputError = m.titleInput.Value() == ""
+		m.passwordInputError = m.passwordInput.Value() == ""
+		if m.titleInputError || m.passwordInputError {
+			return m, nil
+		}
+	}
+	if m.agePathError {
+		return m, nil
+	}
+
+	identities, err := ageIdentities(m.ageKeysInput.Value(), m.agePassphraseInput.Value())
+	if err != nil {
+		m.ageKeysError = true
+		m.agePassphraseError = m.agePassphraseInput.Value() != ""
+		m.errorMessage = err.Error()
+		return m, nil
+	}
+
+	db, err := ReadAgeExport(strings.TrimSpace(m.agePathInput.Value()), identities)
+	if err != nil {
+		m.agePathError = true
+		m.errorMessage = fmt.Sprintf("Failed to read export: %v", err)
 		return m, nil
 	}
 
 	config := ReadConfigFile()
 
-	err := CreatePasswordFile(m.titleInput.Value(), config.DBsFolder, m.passwordInput.Value())
+	if m.importNew {
+		if !m.checkMasterStrength(m.passwordInput.Value(), config.MinMasterScore) {
+			m.passwordInputError = true
+			return m, nil
+		}
+
+		kdf, err := CalibrateKDF(config.UnlockTime, config.KDFMemory*1024)
+		if err != nil {
+			m.setError(fmt.Sprintf("Failed to calibrate key derivation: %v", err))
+			return m, nil
+		}
+
+		crypto := Crypto{Cipher: config.Cipher, Compression: config.Compression}
+		recoveryCode, err := CreatePasswordFile(m.titleInput.Value(), config.DBsFolder, m.passwordInput.Value(), "", crypto, kdf, db.Entries)
+		if err != nil {
+			m.setError(fmt.Sprintf("Failed to create password file: %v", err))
+			return m, nil
+		}
+
+		m.resetToMainMenu()
+		m.recoveryCode = recoveryCode
+		m.recoveryReturnState = stateMainMenu
+		m.state = stateRecoveryCode
+		return m, nil
+	}
+
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	added, skipped, err := ImportEntries(m.vaultPath, key, db.Entries)
 	if err != nil {
-		m.setError(fmt.Sprintf("Failed to create password file: %v", err))
+		m.setError(fmt.Sprintf("Failed to import: %v", err))
 		return m, nil
 	}
 
-	m.state = stateMainMenu
-	m.titleInput = textinput.Model{}
-	m.passwordInput = textinput.Model{}
-	m.titleInputError = false
-	m.passwordInputError = false
+	m.goBack()
 	m.errorMessage = ""
+	m.statusMessage = fmt.Sprintf("Imported %d records, %d already present", added, skipped)
 	return m, nil
 }
 
-// Handle Enter in add record form
-func (m *model) handleAddRecordEnter() (tea.Model, tea.Cmd) {
-	titleEmpty := m.dbTitleInput.Value() == ""
-	passwordEmpty := m.dbPasswordInput.Value() == ""
+// Show change master password form
+func (m *model) openChangePassword() (tea.Model, tea.Cmd) {
+	header, err := ReadPasswordFileHeader(m.vaultPath)
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to read password file: %v", err))
+		return m, nil
+	}
 
-	m.dbTitleInputError = titleEmpty
-	m.dbPasswordInputError = passwordEmpty
+	// Ask for the key file when the slot needs one and the vault was opened without it.
+	// A vault opened with shares gets a new slot instead
+	m.keyFileRequired = false
+	if !m.forcePasswordChange {
+		index, err := header.masterSlot(m.slotID)
+		if err != nil {
+			// The manage menu only shows status messages
+			m.statusMessage = "Unlock with the master password to change it"
+			return m, nil
+		}
+		m.keyFileRequired = header.Slots[index].KeyFile && m.keyFile == ""
+	}
 
-	if titleEmpty || passwordEmpty {
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	// The change re-keys the vault, the form lists what that drops before it is submitted
+	keptSlot := m.slotID
+	if m.forcePasswordChange {
+		keptSlot = ""
+	}
+	m.rekeyLoss, err = PasswordChangeLoss(m.vaultPath, key, keptSlot)
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to read password file: %v", err))
 		return m, nil
 	}
+	m.rekeyConfirmed = false
+
+	m.newPasswordInput = createPasswordInput()
+	m.newPasswordInput.Placeholder = "Enter new password"
+	m.confirmPasswordInput = createPasswordInput()
+	m.confirmPasswordInput.Placeholder = "Repeat new password"
+	m.confirmPasswordInput.Blur()
+	m.keyFileInput = createKeyFileInput()
+	m.newPasswordError = false
+	m.confirmPasswordError = false
+	m.keyFileInputError = false
+	m.errorMessage = ""
+	m.state = stateChangePasswordForm
+	return m, nil
+}
+
+// Handle Enter in recovery shares form
+func (m *model) handleSharesFormEnter() (tea.Model, tea.Cmd) {
+	count, countErr := strconv.Atoi(m.shareCountInput.Value())
+	threshold, thresholdErr := strconv.Atoi(m.shareThresholdInput.Value())
 
-	keyInterface, exists := GlobalStore.Get("key")
-	if !exists {
-		m.setError("Key not found in store")
+	m.shareCountError = countErr != nil
+	m.shareThresholdError = thresholdErr != nil
+
+	if m.shareCountError || m.shareThresholdError {
 		return m, nil
 	}
 
-	key, ok := keyInterface.([]byte)
+	key, ok := m.currentKey()
 	if !ok {
-		m.setError("Invalid key type in store")
 		return m, nil
 	}
 
-	config := ReadConfigFile()
+	shares, err := GenerateRecoveryShares(m.vaultPath, key, count, threshold)
+	if err != nil {
+		m.shareCountError = true
+		m.shareThresholdError = true
+		m.errorMessage = err.Error()
+		return m, nil
+	}
+
+	m.shares = shares
+	m.shareCountInput = textinput.Model{}
+	m.shareThresholdInput = textinput.Model{}
+	m.errorMessage = ""
+	m.state = stateShares
+	return m, nil
+}
+
+// Handle Enter in recovery share input, unlocks once enough shares are collected
+func (m *model) handleShareInputEnter() (tea.Model, tea.Cmd) {
+	text := strings.TrimSpace(m.shareInput.Value())
+	m.shareInputError = text == ""
+	if m.shareInputError {
+		return m, nil
+	}
 
-	err := AddToPasswordFile(config.DBsFolder, m.fileChoice, m.dbTitleInput.Value(), m.dbPasswordInput.Value(), key)
+	share, err := ParseShare(text)
 	if err != nil {
-		m.setError(fmt.Sprintf("Failed to add record: %v", err))
+		m.shareInputError = true
+		m.errorMessage = err.Error()
 		return m, nil
 	}
 
-	newRow := table.Row{m.dbTitleInput.Value(), m.dbPasswordInput.Value()}
-	m.dbData = append(m.dbData, newRow)
-	m.updateTable()
+	for _, collected := range m.collectedShares {
+		if previous, _ := ParseShare(collected); previous.X == share.X {
+			m.shareInputError = true
+			m.errorMessage = "Share already entered"
+			return m, nil
+		}
+	}
 
-	m.state = stateDbView
-	m.dbTitleInput = textinput.Model{}
-	m.dbPasswordInput = textinput.Model{}
-	m.dbTitleInputError = false
-	m.dbPasswordInputError = false
-	m.activeButton = 0
+	m.collectedShares = append(m.collectedShares, text)
+	m.shareInput.Reset()
 	m.errorMessage = ""
 
+	if len(m.collectedShares) < share.Threshold {
+		m.statusMessage = fmt.Sprintf("Share accepted, %d of %d", len(m.collectedShares), share.Threshold)
+		return m, nil
+	}
+
+	isOk, key, err := UnlockWithShares(m.vaultPath, m.collectedShares)
+	m.collectedShares = nil
+	m.statusMessage = ""
+	if err != nil || !isOk {
+		m.shareInputError = true
+		m.errorMessage = "Shares do not unlock this vault, start over"
+		return m, nil
+	}
+
+	// Shares replace a lost master password, so a new one has to be set right away
+	Session.Set(key)
+	wipeBytes(key)
+	m.slotID = ""
+	m.keyFile = ""
+	m.shareInput = textinput.Model{}
+
+	if !m.lockVault() {
+		return m, nil
+	}
+
+	// The new master password would be written into a vault another instance has open
+	if m.readOnly {
+		_, cmd := m.openVault()
+		m.statusMessage = "Read-only, set a new master password once the other instance closes the vault"
+		return m, cmd
+	}
+
+	m.forcePasswordChange = true
+	return m.openChangePassword()
+}
+
+// Show key slots of the open vault
+func (m *model) openKeySlots() (tea.Model, tea.Cmd) {
+	header, err := ReadPasswordFileHeader(m.vaultPath)
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to read password file: %v", err))
+		return m, nil
+	}
+
+	m.slotList, m.slotIDs = createSlotList(header)
+	m.errorMessage = ""
+	m.state = stateKeySlots
 	return m, nil
 }
 
-func (m model) Init() tea.Cmd {
-	return nil
+// Take the lock of the unlocked vault before anything writes it. A second instance gets
+// the vault read-only instead of overwriting the first one's saves
+func (m *model) lockVault() bool {
+	if m.vaultLock != nil {
+		return true
+	}
+
+	lock, err := AcquireVaultLock(m.vaultPath)
+	switch {
+	case errors.Is(err, ErrVaultLocked):
+		m.readOnly = true
+	case err != nil:
+		m.closeVault()
+		m.setError(fmt.Sprintf("Failed to lock password file: %v", err))
+		return false
+	default:
+		m.vaultLock = lock
+		m.readOnly = false
+	}
+	return true
 }
 
-func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	// config := ReadConfigFile()
+// Forget the session key and let other instances write the vault
+func (m *model) closeVault() {
+	Session.Wipe()
+	forgetStamp(m.vaultPath)
+	m.vaultLock.Release()
+	m.vaultLock = nil
+	m.readOnly = false
+}
 
-	if windowMsg, ok := msg.(tea.WindowSizeMsg); ok {
-		m.width = windowMsg.Width
-		m.height = windowMsg.Height
-		m.list.SetWidth(m.width)
-		if m.fileList.Width() > 0 {
-			m.fileList.SetWidth(30)
-		}
+// Reports whether the open vault may be changed, explaining why not otherwise
+func (m *model) writable() bool {
+	if m.readOnly {
+		m.errorMessage = "Read-only, the vault is open in another instance"
+		return false
+	}
+	return true
+}
+
+// Title of the open vault
+func (m model) vaultTitle() string {
+	if m.readOnly {
+		return m.fileChoice + " (read-only)"
+	}
+	return m.fileChoice
+}
+
+// Get the vault key of the open session
+func (m *model) currentKey() ([]byte, bool) {
+	key, ok := Session.Key()
+	if !ok {
+		m.setError("Vault is locked")
+		return nil, false
+	}
+	return key, true
+}
+
+// Handle key slot list keys
+func (m *model) handleKeySlotsKeys(keypress string) (tea.Model, tea.Cmd) {
+	if (keypress == "a" || keypress == "r" || keypress == "d") && !m.writable() {
 		return m, nil
 	}
 
-	if keyMsg, ok := msg.(tea.KeyMsg); ok {
-		// Handle filtering for fileList
-		if m.state == stateFileList && m.fileList.FilterState() != list.Unfiltered {
-			var cmd tea.Cmd
-			m.fileList, cmd = m.fileList.Update(msg)
-			m.fileList.SetWidth(30)
-			return m, cmd
+	switch keypress {
+	case "a":
+		m.slotLabelInput = createTitleInput()
+		m.slotLabelInput.Placeholder = "Enter slot label"
+		m.newPasswordInput = createPasswordInput()
+		m.newPasswordInput.Blur()
+		m.confirmPasswordInput = createPasswordInput()
+		m.confirmPasswordInput.Placeholder = "Repeat password"
+		m.confirmPasswordInput.Blur()
+		m.keyFileInput = createKeyFileInput()
+		m.keyFileInput.Placeholder = "Optional"
+		m.slotLabelError = false
+		m.newPasswordError = false
+		m.confirmPasswordError = false
+		m.keyFileInputError = false
+		m.errorMessage = ""
+		m.state = stateAddSlotForm
+		return m, nil
+	case "r":
+		key, ok := m.currentKey()
+		if !ok {
+			return m, nil
 		}
 
-		// Global keys
-		if model, cmd := m.handleGlobalKeys(keyMsg.String()); cmd != nil || m.quitting {
-			return model, cmd
+		code, err := AddRecoverySlot(m.vaultPath, key, "recovery")
+		if err != nil {
+			m.setError(fmt.Sprintf("Failed to add recovery key: %v", err))
+			return m, nil
 		}
 
-		// Handle keys based on state
-		switch m.state {
-		case stateMainMenu:
-			if keyMsg.String() == "enter" {
-				return m.handleMainMenuEnter()
-			}
-		case stateFileList:
-			if keyMsg.String() == "enter" {
-				return m.handleFileListEnter()
-			}
-		case statePasswordInput:
-			switch keyMsg.String() {
-			case "esc":
-				return m.resetToMainMenu(), nil
-			case "enter":
-				return m.handlePasswordEnter()
-			}
-		case stateAddDbForm:
-			switch keyMsg.String() {
-			case "esc":
-				return m.resetToMainMenu(), nil
-			case "enter":
-				return m.handleAddDbFormEnter()
-			case "tab":
-				if m.titleInput.Focused() {
-					m.titleInput.Blur()
-					m.passwordInput.Focus()
-				} else {
-					m.passwordInput.Blur()
-					m.titleInput.Focus()
-				}
-				return m, nil
-			}
-		case stateDbView:
-			return m.handleDbViewKeys(keyMsg.String())
-		case stateAddRecordForm:
-			switch keyMsg.String() {
-			case "esc":
-				m.state = stateDbView
-				m.dbTitleInput = textinput.Model{}
-				m.dbPasswordInput = textinput.Model{}
-				m.dbTitleInputError = false
-				m.dbPasswordInputError = false
-				return m, nil
-			case "enter":
-				return m.handleAddRecordEnter()
-			case "tab":
-				if m.dbTitleInput.Focused() {
-					m.dbTitleInput.Blur()
-					m.dbPasswordInput.Focus()
-				} else {
-					m.dbPasswordInput.Blur()
-					m.dbTitleInput.Focus()
-				}
-				return m, nil
-			}
-		case stateKeyBindings:
-			if keyMsg.String() == "enter" {
-				m.state = stateMainMenu
-				m.choice = ""
-				return m, nil
-			}
+		m.recoveryCode = code
+		m.recoveryReturnState = stateKeySlots
+		m.state = stateRecoveryCode
+		return m, nil
+	case "d":
+		index := m.slotList.Index()
+		if index < 0 || index >= len(m.slotIDs) {
+			return m, nil
 		}
-	}
 
-	// Update components
-	var cmd tea.Cmd
+		key, ok := m.currentKey()
+		if !ok {
+			return m, nil
+		}
+
+		if err := RemoveSlot(m.vaultPath, key, m.slotIDs[index], m.slotID); err != nil {
+			m.errorMessage = fmt.Sprintf("Failed to remove key slot: %v", err)
+			return m, nil
+		}
+		return m.openKeySlots()
+	}
+	return m, nil
+}
+
+// Handle Enter in add key slot form
+func (m *model) handleAddSlotEnter() (tea.Model, tea.Cmd) {
+	keyFile := expandHome(strings.TrimSpace(m.keyFileInput.Value()))
+
+	m.slotLabelError = m.slotLabelInput.Value() == ""
+	m.newPasswordError = m.newPasswordInput.Value() == ""
+	m.confirmPasswordError = m.confirmPasswordInput.Value() == ""
+	m.keyFileInputError = false
+
+	if m.slotLabelError || m.newPasswordError || m.confirmPasswordError {
+		return m, nil
+	}
+
+	if m.newPasswordInput.Value() != m.confirmPasswordInput.Value() {
+		m.confirmPasswordError = true
+		m.errorMessage = "Passwords do not match"
+		return m, nil
+	}
+
+	if keyFile != "" && !fileExists(keyFile) {
+		m.keyFileInputError = true
+		m.errorMessage = "Key file not found"
+		return m, nil
+	}
+
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	config := ReadConfigFile()
+
+	if !m.checkMasterStrength(m.newPasswordInput.Value(), config.MinMasterScore) {
+		m.newPasswordError = true
+		return m, nil
+	}
+
+	kdf, err := CalibrateKDF(config.UnlockTime, config.KDFMemory*1024)
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to calibrate key derivation: %v", err))
+		return m, nil
+	}
+
+	err = AddPasswordSlot(m.vaultPath, key, m.slotLabelInput.Value(), m.newPasswordInput.Value(), keyFile, kdf)
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to add key slot: %v", err))
+		return m, nil
+	}
+
+	m.slotLabelInput = textinput.Model{}
+	m.newPasswordInput = textinput.Model{}
+	m.confirmPasswordInput = textinput.Model{}
+	m.keyFileInput = textinput.Model{}
+	return m.openKeySlots()
+}
+
+// Show team members of the open vault and the local public key
+func (m *model) openMembers() (tea.Model, tea.Cmd) {
+	header, err := ReadPasswordFileHeader(m.vaultPath)
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to read password file: %v", err))
+		return m, nil
+	}
+
+	m.slotList, m.slotIDs = createMemberList(header)
+	m.localRecipient = ""
+	if identity, err := LoadIdentity(ReadConfigFile().IdentityFile); err == nil {
+		m.localRecipient = identity.Recipient().String()
+	}
+	m.errorMessage = ""
+	m.state = stateMembers
+	return m, nil
+}
+
+// Handle team member list keys
+func (m *model) handleMembersKeys(keypress string) (tea.Model, tea.Cmd) {
+	if (keypress == "a" || keypress == "s" || keypress == "d") && !m.writable() {
+		return m, nil
+	}
+
+	switch keypress {
+	case "a":
+		m.slotLabelInput = createTitleInput()
+		m.slotLabelInput.Placeholder = "Member name"
+		m.ageKeysInput = createAgeKeysInput("age1... public key")
+		m.slotLabelError = false
+		m.ageKeysError = false
+		m.errorMessage = ""
+		m.state = stateAddMemberForm
+		return m, nil
+	case "s":
+		if m.localRecipient == "" {
+			m.errorMessage = "No local identity, press g to generate one"
+			return m, nil
+		}
+
+		key, ok := m.currentKey()
+		if !ok {
+			return m, nil
+		}
+
+		name := os.Getenv("USER")
+		if name == "" {
+			name = "me"
+		}
+
+		if err := AddMember(m.vaultPath, key, name, m.localRecipient); err != nil {
+			m.errorMessage = fmt.Sprintf("Failed to add member: %v", err)
+			return m, nil
+		}
+		return m.openMembers()
+	case "g":
+		identity, err := GenerateIdentity(ReadConfigFile().IdentityFile)
+		if err != nil {
+			m.errorMessage = fmt.Sprintf("Failed to generate identity: %v", err)
+			return m, nil
+		}
+		m.localRecipient = identity.Recipient().String()
+		m.errorMessage = ""
+		return m, nil
+	case "y":
+		if m.localRecipient == "" {
+			return m, nil
+		}
+		copyToClipboard(m.localRecipient)
+		m.statusMessage = "Public key copied"
+		return m, nil
+	case "d":
+		index := m.slotList.Index()
+		if index < 0 || index >= len(m.slotIDs) {
+			return m, nil
+		}
+
+		header, err := ReadPasswordFileHeader(m.vaultPath)
+		if err != nil {
+			m.setError(fmt.Sprintf("Failed to read password file: %v", err))
+			return m, nil
+		}
+
+		m.memberID = m.slotIDs[index]
+		m.passwordInput = createPasswordInput()
+		m.passwordInput.Placeholder = "Master password to keep, optional"
+		m.passwordInputError = false
+		m.keyFileRequired = header.RequiresKeyFile()
+		m.keyFileInput = createKeyFileInput()
+		m.keyFileInput.SetValue(m.keyFile)
+		m.keyFileInputError = false
+		m.errorMessage = ""
+		m.statusMessage = ""
+		m.state = stateRemoveMemberForm
+		return m, nil
+	}
+	return m, nil
+}
+
+// Handle Enter in add team member form
+func (m *model) handleAddMemberEnter() (tea.Model, tea.Cmd) {
+	m.slotLabelError = strings.TrimSpace(m.slotLabelInput.Value()) == ""
+	m.ageKeysError = strings.TrimSpace(m.ageKeysInput.Value()) == ""
+
+	if m.slotLabelError || m.ageKeysError {
+		return m, nil
+	}
+
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	err := AddMember(m.vaultPath, key, strings.TrimSpace(m.slotLabelInput.Value()), m.ageKeysInput.Value())
+	if err != nil {
+		m.ageKeysError = true
+		m.errorMessage = fmt.Sprintf("Failed to add member: %v", err)
+		return m, nil
+	}
+
+	m.slotLabelInput = textinput.Model{}
+	m.ageKeysInput = textinput.Model{}
+	return m.openMembers()
+}
+
+// Handle Enter in remove team member form, the vault gets a new data key
+func (m *model) handleRemoveMemberEnter() (tea.Model, tea.Cmd) {
+	keyFile := expandHome(strings.TrimSpace(m.keyFileInput.Value()))
+
+	m.passwordInputError = false
+	m.keyFileInputError = false
+
+	if keyFile != "" && !fileExists(keyFile) {
+		m.keyFileInputError = true
+		m.errorMessage = "Key file not found"
+		return m, nil
+	}
+
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	result, err := RemoveMember(m.vaultPath, key, m.memberID, m.passwordInput.Value(), keyFile)
+	if err != nil {
+		m.passwordInputError = true
+		m.errorMessage = fmt.Sprintf("Failed to remove member: %v", err)
+		return m, nil
+	}
+
+	Session.Set(result.Key)
+	wipeBytes(result.Key)
+
+	m.memberID = ""
+	m.passwordInput = textinput.Model{}
+	m.keyFileInput = textinput.Model{}
+
+	model, cmd := m.openMembers()
+	m.statusMessage = "Member removed, vault re-keyed"
+	if result.Dropped > 0 {
+		m.statusMessage += fmt.Sprintf(", %d password or recovery slots dropped", result.Dropped)
+	}
+
+	if result.RecoveryCode != "" {
+		m.recoveryCode = result.RecoveryCode
+		m.recoveryReturnState = stateMembers
+		m.state = stateRecoveryCode
+	}
+	return model, cmd
+}
+
+// Show backups of the open vault, newest first
+func (m *model) openBackups() (tea.Model, tea.Cmd) {
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	backups, err := ListBackups(m.vaultPath)
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to list backups: %v", err))
+		return m, nil
+	}
+
+	m.backups = backups
+	m.backupList = createBackupList(m.vaultPath, backups, key)
+	m.errorMessage = ""
+	m.state = stateBackups
+	return m, nil
+}
+
+// Handle Enter in backup list
+func (m *model) handleBackupEnter() (tea.Model, tea.Cmd) {
+	index := m.backupList.Index()
+	if index < 0 || index >= len(m.backups) {
+		return m, nil
+	}
+
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	backup := m.backups[index]
+	count, err := RestoreBackup(m.vaultPath, backup, key)
+	if err != nil {
+		m.errorMessage = fmt.Sprintf("Failed to restore: %v", err)
+		return m, nil
+	}
+
+	if err := m.reloadEntries(key); err != nil {
+		m.setError(fmt.Sprintf("Failed to read file: %v", err))
+		return m, nil
+	}
+
+	m.goBack()
+	m.statusMessage = fmt.Sprintf("Restored %d records from %s, the previous state was backed up",
+		count, backup.Time.Local().Format("2006-01-02 15:04:05"))
+	return m, nil
+}
+
+// Handle Enter in change master password form
+func (m *model) handleChangePasswordEnter() (tea.Model, tea.Cmd) {
+	if !m.writable() {
+		return m, nil
+	}
+
+	keyFile := m.keyFile
+	if m.keyFileRequired {
+		keyFile = expandHome(strings.TrimSpace(m.keyFileInput.Value()))
+	}
+
+	m.newPasswordError = m.newPasswordInput.Value() == ""
+	m.confirmPasswordError = m.confirmPasswordInput.Value() == ""
+	m.keyFileInputError = m.keyFileRequired && keyFile == ""
+
+	if m.newPasswordError || m.confirmPasswordError || m.keyFileInputError {
+		return m, nil
+	}
+
+	if m.newPasswordInput.Value() != m.confirmPasswordInput.Value() {
+		m.confirmPasswordError = true
+		m.errorMessage = "Passwords do not match"
+		return m, nil
+	}
+
+	if m.keyFileRequired && !fileExists(keyFile) {
+		m.keyFileInputError = true
+		m.errorMessage = "Key file not found"
+		return m, nil
+	}
+
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	config := ReadConfigFile()
+
+	if !m.checkMasterStrength(m.newPasswordInput.Value(), config.MinMasterScore) {
+		m.newPasswordError = true
+		return m, nil
+	}
+
+	// Fresh salt and parameters calibrated for this machine
+	kdf, err := CalibrateKDF(config.UnlockTime, config.KDFMemory*1024)
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to calibrate key derivation: %v", err))
+		return m, nil
+	}
+
+	// Slots and shares that stop working are confirmed with a second Enter
+	if m.rekeyLoss.Any() && !m.rekeyConfirmed {
+		m.rekeyConfirmed = true
+		m.errorMessage = "Press Enter again to change the password and drop them"
+		return m, nil
+	}
+
+	// Either way the vault gets a new data key
+	var result RekeyResult
+	if m.forcePasswordChange {
+		result, m.slotID, err = ResetMasterPassword(m.vaultPath, key, m.newPasswordInput.Value(), keyFile, kdf)
+	} else {
+		result, err = ChangeMasterPassword(m.vaultPath, key, m.slotID, m.newPasswordInput.Value(), keyFile, kdf)
+	}
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to change master password: %v", err))
+		return m, nil
+	}
+
+	Session.Set(result.Key)
+	wipeBytes(result.Key)
+
+	m.keyFile = keyFile
+	m.newPasswordInput = textinput.Model{}
+	m.confirmPasswordInput = textinput.Model{}
+	m.keyFileInput = textinput.Model{}
+	m.keyFileRequired = false
+	m.newPasswordError = false
+	m.confirmPasswordError = false
+	m.keyFileInputError = false
+	m.errorMessage = ""
+	m.state = stateManageMenu
+
+	var model tea.Model = m
+	var cmd tea.Cmd
+	if m.forcePasswordChange {
+		m.forcePasswordChange = false
+		model, cmd = m.openVault()
+	}
+
+	m.statusMessage = "Master password changed, vault re-keyed"
+	if result.Dropped > 0 {
+		m.statusMessage += fmt.Sprintf(", %d password or recovery slots dropped", result.Dropped)
+	}
+
+	if result.RecoveryCode != "" {
+		m.recoveryCode = result.RecoveryCode
+		m.recoveryReturnState = m.state
+		m.state = stateRecoveryCode
+	}
+	return model, cmd
+}
+
+// Explains what a password change drops, shown in the form before it is submitted
+func rekeyLossText(loss RekeyLoss) string {
+	var dropped []string
+	if loss.Passwords > 0 {
+		dropped = append(dropped, fmt.Sprintf("%d other password slots are removed", loss.Passwords))
+	}
+	if loss.Recovery > 0 {
+		dropped = append(dropped, fmt.Sprintf("%d recovery keys are replaced by a new one", loss.Recovery))
+	}
+	if loss.ShareSets > 0 {
+		dropped = append(dropped, fmt.Sprintf("%d sets of recovery shares stop working", loss.ShareSets))
+	}
+
+	text := "The vault gets a new key. Member slots are kept\nand older backups can still be restored."
+	if len(dropped) > 0 {
+		text += "\n" + strings.Join(dropped, ",\n") + "."
+	}
+	return text
+}
+
+// Rejects master passwords below the configured minimum strength score
+func (m *model) checkMasterStrength(password string, minScore int) bool {
+	if minScore <= 0 {
+		return true
+	}
+
+	if result := EstimateStrength(password); result.Score < minScore {
+		m.errorMessage = fmt.Sprintf("Master password is too weak (%d/4), at least %d/4 is required", result.Score, minScore)
+		return false
+	}
+	return true
+}
+
+// Handle Enter in add DB form
+func (m *model) handleAddDbFormEnter() (tea.Model, tea.Cmd) {
+	titleEmpty := m.titleInput.Value() == ""
+	passwordEmpty := m.passwordInput.Value() == ""
+
+	m.titleInputError = titleEmpty
+	m.passwordInputError = passwordEmpty
+
+	if titleEmpty || passwordEmpty {
+		return m, nil
+	}
+
+	config := ReadConfigFile()
+
+	if !m.checkMasterStrength(m.passwordInput.Value(), config.MinMasterScore) {
+		m.passwordInputError = true
+		return m, nil
+	}
+
+	// Calibrate Argon2id for the configured unlock time on this machine
+	kdf, err := CalibrateKDF(config.UnlockTime, config.KDFMemory*1024)
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to calibrate key derivation: %v", err))
+		return m, nil
+	}
+
+	crypto := Crypto{Cipher: config.Cipher, Compression: config.Compression}
+
+	// A missing key file is generated, an existing one is used as is
+	keyFile := expandHome(strings.TrimSpace(m.keyFileInput.Value()))
+	generated := false
+	m.statusMessage = ""
+	if keyFile != "" && !fileExists(keyFile) {
+		if err := GenerateKeyFile(keyFile); err != nil {
+			m.setError(fmt.Sprintf("Failed to create key file: %v", err))
+			return m, nil
+		}
+		generated = true
+		m.statusMessage = fmt.Sprintf("Key file created at %s, keep a copy of it", keyFile)
+	}
+
+	recoveryCode, err := CreatePasswordFile(m.titleInput.Value(), config.DBsFolder, m.passwordInput.Value(), keyFile, crypto, kdf, nil)
+	if err != nil {
+		// A key file made for a vault that was not created would only be left behind
+		if generated {
+			os.Remove(keyFile)
+		}
+		m.statusMessage = ""
+		m.setError(fmt.Sprintf("Failed to create password file: %v", err))
+		return m, nil
+	}
+
+	// Recovery code is shown once before returning to the main menu
+	m.recoveryCode = recoveryCode
+	m.recoveryReturnState = stateMainMenu
+	m.state = stateRecoveryCode
+	m.titleInput = textinput.Model{}
+	m.passwordInput = textinput.Model{}
+	m.keyFileInput = textinput.Model{}
+	m.titleInputError = false
+	m.passwordInputError = false
+	m.errorMessage = ""
+	return m, nil
+}
+
+// Fill the record password with a generated one
+func (m *model) generateRecordPassword() (tea.Model, tea.Cmd) {
+	config := ReadConfigFile()
+
+	password, err := GeneratePassword(config.Generator)
+	if err != nil {
+		m.dbPasswordInputError = true
+		m.errorMessage = fmt.Sprintf("Failed to generate password: %v", err)
+		return m, nil
+	}
+
+	m.dbPasswordInput.SetValue(password)
+	m.dbPasswordInputError = false
+	m.errorMessage = ""
+	return m, nil
+}
+
+// Fill the master password with a generated passphrase and show it, the input is masked
+func (m *model) generateMasterPassphrase() (tea.Model, tea.Cmd) {
+	config := ReadConfigFile()
+
+	// Forms with a repeat field get the passphrase in both
+	input, inputError := &m.passwordInput, &m.passwordInputError
+	repeat := m.state == stateChangePasswordForm || m.state == stateAddSlotForm
+	if repeat {
+		input, inputError = &m.newPasswordInput, &m.newPasswordError
+	}
+
+	passphrase, entropy, err := GeneratePassphrase(config.Passphrase)
+	if err != nil {
+		*inputError = true
+		m.errorMessage = fmt.Sprintf("Failed to generate passphrase: %v", err)
+		return m, nil
+	}
+
+	input.SetValue(passphrase)
+	*inputError = false
+	if repeat {
+		m.confirmPasswordInput.SetValue(passphrase)
+		m.confirmPasswordError = false
+	}
+	m.errorMessage = ""
+	m.statusMessage = fmt.Sprintf("%s (%.0f bits)", passphrase, entropy)
+	return m, nil
+}
+
+// Handle Enter in add record form
+func (m *model) handleAddRecordEnter() (tea.Model, tea.Cmd) {
+	titleEmpty := m.dbTitleInput.Value() == ""
+	passwordEmpty := m.dbPasswordInput.Value() == ""
+	otpSecret := strings.TrimSpace(m.dbOTPInput.Value())
+
+	m.dbTitleInputError = titleEmpty
+	m.dbPasswordInputError = passwordEmpty
+	m.dbOTPInputError = false
+
+	if titleEmpty || passwordEmpty {
+		return m, nil
+	}
+
+	if otpSecret != "" {
+		if _, err := ParseOTP(otpSecret); err != nil {
+			m.dbOTPInputError = true
+			m.errorMessage = err.Error()
+			return m, nil
+		}
+	}
+
+	config := ReadConfigFile()
+
+	if !m.checkRecordBreach(config.BreachFile) {
+		m.dbPasswordInputError = true
+		return m, nil
+	}
+
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	entry := Entry{
+		Title:    m.dbTitleInput.Value(),
+		Username: m.dbUsernameInput.Value(),
+		Password: m.dbPasswordInput.Value(),
+		URL:      m.dbURLInput.Value(),
+		OTP:      otpSecret,
+		Created:  time.Now(),
+	}
+
+	err := AddToPasswordFile(m.vaultPath, entry, key)
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to add record: %v", err))
+		return m, nil
+	}
+
+	if err := m.reloadEntries(key); err != nil {
+		m.setError(fmt.Sprintf("Failed to read file: %v", err))
+		return m, nil
+	}
+
+	m.state = stateDbView
+	m.dbTitleInput = textinput.Model{}
+	m.dbUsernameInput = textinput.Model{}
+	m.dbPasswordInput = textinput.Model{}
+	m.dbURLInput = textinput.Model{}
+	m.dbOTPInput = textinput.Model{}
+	m.dbTitleInputError = false
+	m.dbPasswordInputError = false
+	m.dbOTPInputError = false
+	m.breachWarnedPassword = ""
+	m.activeButton = 0
+	m.errorMessage = ""
+
+	return m, nil
+}
+
+// Warns once about a record password found in the breach dump, Enter again saves it anyway
+func (m *model) checkRecordBreach(breachFile string) bool {
+	password := m.dbPasswordInput.Value()
+	if breachFile == "" || m.breachWarnedPassword == password {
+		return true
+	}
+
+	count, err := CheckBreachedPassword(breachFile, password)
+	if err != nil {
+		m.breachWarnedPassword = password
+		m.errorMessage = fmt.Sprintf("Breach check failed: %v, press Enter again to save", err)
+		return false
+	}
+	if count > 0 {
+		m.breachWarnedPassword = password
+		m.errorMessage = fmt.Sprintf("Password found in %d breaches, press Enter again to save it anyway", count)
+		return false
+	}
+	return true
+}
+
+// Check every record password against the breach dump and mark the ones found
+func (m *model) scanBreachedRecords() (tea.Model, tea.Cmd) {
+	breached, err := ScanBreachedEntries(ReadConfigFile().BreachFile, m.entries)
+	if err != nil {
+		m.errorMessage = fmt.Sprintf("Breach scan failed: %v", err)
+		return m, nil
+	}
+
+	m.breached = breached
+	m.updateTable()
+
+	if len(breached) == 0 {
+		m.errorMessage = ""
+		m.statusMessage = "No breached passwords found"
+		return m, nil
+	}
+	m.statusMessage = ""
+	m.errorMessage = fmt.Sprintf("%d of %d passwords found in breaches, marked with !", len(breached), len(m.entries))
+	return m, nil
+}
+
+func (m model) Init() tea.Cmd {
+	return nil
+}
+
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	next, cmd := m.update(msg)
+
+	// Handlers return either the model or a pointer to it
+	switch next := next.(type) {
+	case model:
+		next.refreshStrength()
+		return next, cmd
+	case *model:
+		next.refreshStrength()
+		return next, cmd
+	}
+	return next, cmd
+}
+
+// Password input that shows a strength meter in the current form
+func (m *model) strengthInput() *textinput.Model {
+	switch m.state {
+	case stateAddDbForm:
+		return &m.passwordInput
+	case stateImportForm:
+		if m.importNew {
+			return &m.passwordInput
+		}
+	case stateAddRecordForm, stateEditRecordForm:
+		return &m.dbPasswordInput
+	case stateChangePasswordForm, stateAddSlotForm:
+		return &m.newPasswordInput
+	case stateExportForm, stateShareEntryForm:
+		return &m.agePassphraseInput
+	}
+	return nil
+}
+
+// Estimates the meter password once per change, View runs on every cursor blink
+func (m *model) refreshStrength() {
+	input := m.strengthInput()
+	if input == nil || input.Value() == "" {
+		m.strengthPassword = ""
+		m.strength = StrengthResult{}
+		return
+	}
+
+	if input.Value() != m.strengthPassword {
+		m.strengthPassword = input.Value()
+		m.strength = EstimateStrength(m.strengthPassword)
+	}
+}
+
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	// config := ReadConfigFile()
+
+	if windowMsg, ok := msg.(tea.WindowSizeMsg); ok {
+		m.width = windowMsg.Width
+		m.height = windowMsg.Height
+		m.list.SetWidth(m.width)
+		if m.fileList.Width() > 0 {
+			m.fileList.SetWidth(30)
+		}
+		return m, nil
+	}
+
+	if tick, ok := msg.(otpTickMsg); ok {
+		// The chain stops once the vault is closed or reopened
+		if _, open := Session.Key(); !open || tick.id != m.otpTick {
+			return m, nil
+		}
+		m.updateTable()
+		return m, otpTick(m.otpTick)
+	}
+
+	if keyMsg, ok := msg.(tea.KeyMsg); ok {
+		// Handle filtering for fileList
+		if m.state == stateFileList && m.fileList.FilterState() != list.Unfiltered {
+			var cmd tea.Cmd
+			m.fileList, cmd = m.fileList.Update(msg)
+			m.fileList.SetWidth(30)
+			return m, cmd
+		}
+
+		// Global keys
+		if model, cmd := m.handleGlobalKeys(keyMsg.String()); cmd != nil || m.quitting {
+			return model, cmd
+		}
+
+		// Handle keys based on state
+		switch m.state {
+		case stateMainMenu:
+			if keyMsg.String() == "enter" {
+				return m.handleMainMenuEnter()
+			}
+		case stateFileList:
+			if keyMsg.String() == "enter" {
+				return m.handleFileListEnter()
+			}
+		case statePasswordInput:
+			switch keyMsg.String() {
+			case "esc":
+				return m.resetToMainMenu(), nil
+			case "enter":
+				return m.handlePasswordEnter()
+			case "tab":
+				if m.keyFileRequired {
+					focusNextInput(&m.passwordInput, &m.keyFileInput)
+				}
+				return m, nil
+			case "ctrl+r":
+				m.shareInput = createShareInput()
+				m.shareInputError = false
+				m.collectedShares = nil
+				m.errorMessage = ""
+				m.statusMessage = ""
+				m.state = stateShareInput
+				return m, nil
+			}
+		case stateAddDbForm:
+			switch keyMsg.String() {
+			case "esc":
+				return m.resetToMainMenu(), nil
+			case "enter":
+				return m.handleAddDbFormEnter()
+			case "ctrl+g":
+				return m.generateMasterPassphrase()
+			case "tab":
+				focusNextInput(&m.titleInput, &m.passwordInput, &m.keyFileInput)
+				return m, nil
+			}
+		case stateDbView:
+			return m.handleDbViewKeys(keyMsg.String())
+		case stateManageMenu:
+			if keyMsg.String() == "enter" {
+				return m.handleManageMenuEnter()
+			}
+		case stateKeySlots:
+			return m.handleKeySlotsKeys(keyMsg.String())
+		case stateMembers:
+			return m.handleMembersKeys(keyMsg.String())
+		case stateBackups:
+			if keyMsg.String() == "enter" {
+				return m.handleBackupEnter()
+			}
+		case stateAddMemberForm:
+			switch keyMsg.String() {
+			case "esc":
+				return m.goBack(), nil
+			case "enter":
+				return m.handleAddMemberEnter()
+			case "tab":
+				focusNextInput(&m.slotLabelInput, &m.ageKeysInput)
+				return m, nil
+			}
+		case stateRemoveMemberForm:
+			switch keyMsg.String() {
+			case "esc":
+				return m.goBack(), nil
+			case "enter":
+				return m.handleRemoveMemberEnter()
+			case "tab":
+				if m.keyFileRequired {
+					focusNextInput(&m.passwordInput, &m.keyFileInput)
+				}
+				return m, nil
+			}
+		case stateSharesForm:
+			switch keyMsg.String() {
+			case "esc":
+				return m.goBack(), nil
+			case "enter":
+				return m.handleSharesFormEnter()
+			case "tab":
+				focusNextInput(&m.shareCountInput, &m.shareThresholdInput)
+				return m, nil
+			}
+		case stateShares:
+			if keyMsg.String() == "enter" {
+				return m.goBack(), nil
+			}
+			return m, nil
+		case stateExportForm:
+			switch keyMsg.String() {
+			case "esc":
+				return m.goBack(), nil
+			case "enter":
+				return m.handleExportEnter()
+			case "tab":
+				focusNextInput(&m.agePathInput, &m.ageKeysInput, &m.agePassphraseInput)
+				return m, nil
+			}
+		case stateImportForm:
+			switch keyMsg.String() {
+			case "esc":
+				if m.importNew {
+					return m.resetToMainMenu(), nil
+				}
+				return m.goBack(), nil
+			case "enter":
+				return m.handleImportEnter()
+			case "ctrl+g":
+				if m.importNew {
+					return m.generateMasterPassphrase()
+				}
+				return m, nil
+			case "tab":
+				if m.importNew {
+					focusNextInput(&m.titleInput, &m.passwordInput, &m.agePathInput, &m.ageKeysInput, &m.agePassphraseInput)
+				} else {
+					focusNextInput(&m.agePathInput, &m.ageKeysInput, &m.agePassphraseInput)
+				}
+				return m, nil
+			}
+		case stateShareEntryForm, stateImportBundleForm:
+			switch keyMsg.String() {
+			case "esc":
+				return m.goBack(), nil
+			case "enter":
+				if m.state == stateShareEntryForm {
+					return m.handleShareEntryEnter()
+				}
+				return m.handleImportBundleEnter()
+			case "tab":
+				focusNextInput(&m.agePathInput, &m.ageKeysInput, &m.agePassphraseInput)
+				return m, nil
+			}
+		case stateShareInput:
+			switch keyMsg.String() {
+			case "esc":
+				return m.goBack(), nil
+			case "enter":
+				return m.handleShareInputEnter()
+			}
+		case stateAddSlotForm:
+			switch keyMsg.String() {
+			case "esc":
+				return m.goBack(), nil
+			case "enter":
+				return m.handleAddSlotEnter()
+			case "ctrl+g":
+				return m.generateMasterPassphrase()
+			case "tab":
+				focusNextInput(&m.slotLabelInput, &m.newPasswordInput, &m.confirmPasswordInput, &m.keyFileInput)
+				return m, nil
+			}
+		case stateRecoveryCode:
+			if keyMsg.String() == "enter" {
+				m.recoveryCode = ""
+				if m.recoveryReturnState == stateKeySlots {
+					return m.openKeySlots()
+				}
+				if m.recoveryReturnState == stateMembers {
+					// Keep the re-key status of openMembers
+					m.state = stateMembers
+					return m, nil
+				}
+				m.state = m.recoveryReturnState
+				return m, nil
+			}
+			return m, nil
+		case stateChangePasswordForm:
+			switch keyMsg.String() {
+			case "esc":
+				if m.forcePasswordChange {
+					return m.resetToMainMenu(), nil
+				}
+				return m.goBack(), nil
+			case "enter":
+				return m.handleChangePasswordEnter()
+			case "ctrl+g":
+				return m.generateMasterPassphrase()
+			case "tab":
+				if m.keyFileRequired {
+					focusNextInput(&m.newPasswordInput, &m.confirmPasswordInput, &m.keyFileInput)
+				} else {
+					focusNextInput(&m.newPasswordInput, &m.confirmPasswordInput)
+				}
+				return m, nil
+			}
+		case stateAddRecordForm:
+			switch keyMsg.String() {
+			case "esc":
+				return m.goBack(), nil
+			case "enter":
+				return m.handleAddRecordEnter()
+			case "ctrl+g":
+				return m.generateRecordPassword()
+			case "tab":
+				focusNextInput(&m.dbTitleInput, &m.dbUsernameInput, &m.dbPasswordInput, &m.dbURLInput, &m.dbOTPInput)
+				return m, nil
+			}
+		case stateEditRecordForm:
+			switch keyMsg.String() {
+			case "esc":
+				return m.goBack(), nil
+			case "enter":
+				return m.handleEditRecordEnter()
+			case "ctrl+g":
+				return m.generateRecordPassword()
+			case "tab":
+				focusNextInput(&m.dbTitleInput, &m.dbUsernameInput, &m.dbPasswordInput, &m.dbURLInput, &m.dbOTPInput)
+				return m, nil
+			}
+		case stateHistory:
+			if keyMsg.String() == "enter" {
+				return m.handleHistoryEnter()
+			}
+		case stateHistoryDepthForm:
+			switch keyMsg.String() {
+			case "esc":
+				return m.goBack(), nil
+			case "enter":
+				return m.handleHistoryDepthEnter()
+			}
+		case stateKeyBindings:
+			if keyMsg.String() == "enter" {
+				m.state = stateMainMenu
+				m.choice = ""
+				return m, nil
+			}
+		}
+	}
+
+	// Update components
+	var cmd tea.Cmd
 	switch m.state {
 	case stateMainMenu:
 		m.list, cmd = m.list.Update(msg)
@@ -670,125 +2679,520 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.fileList.SetWidth(30)
 	case statePasswordInput:
 		m.passwordInput, cmd = m.passwordInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.keyFileInput, cmd = m.keyFileInput.Update(msg)
 	case stateAddDbForm:
 		m.titleInput, cmd = m.titleInput.Update(msg)
 		if cmd != nil {
 			return m, cmd
 		}
 		m.passwordInput, cmd = m.passwordInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.keyFileInput, cmd = m.keyFileInput.Update(msg)
 	case stateDbView:
 		m.table, cmd = m.table.Update(msg)
-	case stateAddRecordForm:
+	case stateAddRecordForm, stateEditRecordForm:
 		m.dbTitleInput, cmd = m.dbTitleInput.Update(msg)
 		if cmd != nil {
 			return m, cmd
 		}
+		m.dbUsernameInput, cmd = m.dbUsernameInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
 		m.dbPasswordInput, cmd = m.dbPasswordInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.dbURLInput, cmd = m.dbURLInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.dbOTPInput, cmd = m.dbOTPInput.Update(msg)
+	case stateHistory:
+		m.historyList, cmd = m.historyList.Update(msg)
+	case stateHistoryDepthForm:
+		m.historyDepthInput, cmd = m.historyDepthInput.Update(msg)
+	case stateManageMenu:
+		m.manageList, cmd = m.manageList.Update(msg)
+	case stateChangePasswordForm:
+		m.newPasswordInput, cmd = m.newPasswordInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.confirmPasswordInput, cmd = m.confirmPasswordInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.keyFileInput, cmd = m.keyFileInput.Update(msg)
+	case stateKeySlots, stateMembers:
+		m.slotList, cmd = m.slotList.Update(msg)
+	case stateBackups:
+		m.backupList, cmd = m.backupList.Update(msg)
+	case stateAddMemberForm:
+		m.slotLabelInput, cmd = m.slotLabelInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.ageKeysInput, cmd = m.ageKeysInput.Update(msg)
+	case stateRemoveMemberForm:
+		m.passwordInput, cmd = m.passwordInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.keyFileInput, cmd = m.keyFileInput.Update(msg)
+	case stateSharesForm:
+		m.shareCountInput, cmd = m.shareCountInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.shareThresholdInput, cmd = m.shareThresholdInput.Update(msg)
+	case stateShareInput:
+		m.shareInput, cmd = m.shareInput.Update(msg)
+	case stateExportForm, stateImportForm:
+		if m.importNew {
+			m.titleInput, cmd = m.titleInput.Update(msg)
+			if cmd != nil {
+				return m, cmd
+			}
+			m.passwordInput, cmd = m.passwordInput.Update(msg)
+			if cmd != nil {
+				return m, cmd
+			}
+		}
+		m.agePathInput, cmd = m.agePathInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.ageKeysInput, cmd = m.ageKeysInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.agePassphraseInput, cmd = m.agePassphraseInput.Update(msg)
+	case stateShareEntryForm, stateImportBundleForm:
+		m.agePathInput, cmd = m.agePathInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.ageKeysInput, cmd = m.ageKeysInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.agePassphraseInput, cmd = m.agePassphraseInput.Update(msg)
+	case stateAddSlotForm:
+		m.slotLabelInput, cmd = m.slotLabelInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.newPasswordInput, cmd = m.newPasswordInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.confirmPasswordInput, cmd = m.confirmPasswordInput.Update(msg)
+		if cmd != nil {
+			return m, cmd
+		}
+		m.keyFileInput, cmd = m.keyFileInput.Update(msg)
+	}
+
+	return m, cmd
+}
+
+// Handle DbView keys
+func (m *model) handleDbViewKeys(key string) (tea.Model, tea.Cmd) {
+	switch key {
+	case "a":
+		return m.openAddRecordForm()
+	case "e":
+		return m.openEditRecordForm()
+	case "h":
+		return m.openHistory()
+	case "d":
+		return m.deleteSelectedRecord()
+	case "c":
+		return m.copySelectedOTP()
+	case "p":
+		return m.scanBreachedRecords()
+	case "s":
+		return m.openShareEntryForm()
+	case "i":
+		return m.openImportBundleForm()
+	case "l":
+		// Lock the vault and ask for the master password again
+		m.closeVault()
+		m.dbData = []table.Row{}
+		m.entries = nil
+		m.breached = nil
+		m.rollbackWarning = ""
+		m.keyFile = ""
+		m.slotID = ""
+		m.statusMessage = ""
+		return m.openPasswordPrompt(m.fileChoice)
+	case "left":
+		m.activeButton--
+		if m.activeButton < 0 {
+			m.activeButton = len(dbViewButtons) - 1
+		}
+		return m, nil
+	case "right":
+		m.activeButton++
+		if m.activeButton >= len(dbViewButtons) {
+			m.activeButton = 0
+		}
+		return m, nil
+	case "enter":
+		switch dbViewButtons[m.activeButton] {
+		case "Add":
+			return m.openAddRecordForm()
+		case "Edit":
+			return m.openEditRecordForm()
+		case "History":
+			return m.openHistory()
+		case "Delete":
+			return m.deleteSelectedRecord()
+		}
+		return m, nil
+	}
+	return m, nil
+}
+
+// Show edit form of the selected record
+func (m *model) openEditRecordForm() (tea.Model, tea.Cmd) {
+	if !m.writable() {
+		return m, nil
+	}
+
+	selectedIndex := m.table.Cursor()
+	if selectedIndex >= len(m.entries) {
+		return m, nil
+	}
+	entry := m.entries[selectedIndex]
+
+	m.dbTitleInput = createDbTitleInput()
+	m.dbTitleInput.SetValue(entry.Title)
+	m.dbUsernameInput = createDbUsernameInput()
+	m.dbUsernameInput.SetValue(entry.Username)
+	m.dbPasswordInput = createDbPasswordInput()
+	m.dbPasswordInput.SetValue(entry.Password)
+	m.dbPasswordInput.Blur()
+	m.dbURLInput = createDbURLInput()
+	m.dbURLInput.SetValue(entry.URL)
+	m.dbOTPInput = createDbOTPInput()
+	m.dbOTPInput.SetValue(entry.OTP)
+	m.dbTitleInputError = false
+	m.dbPasswordInputError = false
+	m.dbOTPInputError = false
+	m.breachWarnedPassword = ""
+	m.errorMessage = ""
+	m.statusMessage = ""
+	m.state = stateEditRecordForm
+	return m, nil
+}
+
+// Handle Enter in edit record form
+func (m *model) handleEditRecordEnter() (tea.Model, tea.Cmd) {
+	selectedIndex := m.table.Cursor()
+	if selectedIndex >= len(m.entries) {
+		return m.goBack(), nil
+	}
+	current := m.entries[selectedIndex]
+
+	otpSecret := strings.TrimSpace(m.dbOTPInput.Value())
+
+	m.dbTitleInputError = m.dbTitleInput.Value() == ""
+	m.dbPasswordInputError = m.dbPasswordInput.Value() == ""
+	m.dbOTPInputError = false
+
+	if m.dbTitleInputError || m.dbPasswordInputError {
+		return m, nil
+	}
+
+	if otpSecret != "" {
+		if _, err := ParseOTP(otpSecret); err != nil {
+			m.dbOTPInputError = true
+			m.errorMessage = err.Error()
+			return m, nil
+		}
+	}
+
+	// Only a new password is checked against the breach file
+	if m.dbPasswordInput.Value() != current.Password && !m.checkRecordBreach(ReadConfigFile().BreachFile) {
+		m.dbPasswordInputError = true
+		return m, nil
+	}
+
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	updated := current
+	updated.Title = m.dbTitleInput.Value()
+	updated.Username = m.dbUsernameInput.Value()
+	updated.Password = m.dbPasswordInput.Value()
+	updated.URL = m.dbURLInput.Value()
+	updated.OTP = otpSecret
+
+	if err := UpdateEntry(m.vaultPath, selectedIndex, updated, key); err != nil {
+		m.errorMessage = fmt.Sprintf("Failed to save record: %v", err)
+		return m, nil
+	}
+
+	if err := m.reloadEntries(key); err != nil {
+		m.setError(fmt.Sprintf("Failed to read file: %v", err))
+		return m, nil
+	}
+
+	m.goBack()
+	m.errorMessage = ""
+	return m, nil
+}
+
+// Show previous versions of the selected record
+func (m *model) openHistory() (tea.Model, tea.Cmd) {
+	selectedIndex := m.table.Cursor()
+	if selectedIndex >= len(m.entries) {
+		return m, nil
+	}
+
+	m.historyList = createHistoryList(m.entries[selectedIndex])
+	m.errorMessage = ""
+	m.statusMessage = ""
+	m.state = stateHistory
+	return m, nil
+}
+
+// Handle Enter in history view, the selected version becomes the current one
+func (m *model) handleHistoryEnter() (tea.Model, tea.Cmd) {
+	if !m.writable() {
+		return m, nil
+	}
+
+	selectedIndex := m.table.Cursor()
+	if selectedIndex >= len(m.entries) {
+		return m, nil
+	}
+	entry := m.entries[selectedIndex]
+
+	versionIndex := m.historyList.Index()
+	if versionIndex < 0 || versionIndex >= len(entry.History) {
+		return m, nil
+	}
+	changed := entry.History[versionIndex].Changed
+
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	if err := RestoreEntryVersion(m.vaultPath, selectedIndex, versionIndex, entry, key); err != nil {
+		m.errorMessage = fmt.Sprintf("Failed to restore version: %v", err)
+		return m, nil
+	}
+
+	if err := m.reloadEntries(key); err != nil {
+		m.setError(fmt.Sprintf("Failed to read file: %v", err))
+		return m, nil
+	}
+
+	m.historyList = createHistoryList(m.entries[selectedIndex])
+	m.errorMessage = ""
+	m.statusMessage = fmt.Sprintf("Restored the version from %s", changed.Local().Format("2006-01-02 15:04"))
+	return m, nil
+}
+
+// Field by field comparison of a previous version with the current record
+func renderHistoryDiff(version EntryVersion, current Entry) string {
+	var lines []string
+	for _, field := range []struct {
+		name     string
+		old, new string
+	}{
+		{"Username", version.Username, current.Username},
+		{"Password", version.Password, current.Password},
+		{"URL", version.URL, current.URL},
+	} {
+		if field.old == field.new {
+			lines = append(lines, fmt.Sprintf("%-9s %s", field.name, field.old))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%-9s %s → %s", field.name, diffOldStyle.Render(field.old), diffNewStyle.Render(field.new)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Show the history depth form with the current depth
+func (m *model) openHistoryDepthForm() (tea.Model, tea.Cmd) {
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	depth, err := ReadHistoryDepth(m.vaultPath, key)
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to read password file: %v", err))
+		return m, nil
+	}
+
+	m.historyDepthInput = createNumberInput("Versions kept per record")
+	m.historyDepthInput.SetValue(strconv.Itoa(depth))
+	m.historyDepthInput.Focus()
+	m.historyDepthError = false
+	m.errorMessage = ""
+	m.state = stateHistoryDepthForm
+	return m, nil
+}
+
+// Handle Enter in history depth form
+func (m *model) handleHistoryDepthEnter() (tea.Model, tea.Cmd) {
+	depth, err := strconv.Atoi(strings.TrimSpace(m.historyDepthInput.Value()))
+	m.historyDepthError = err != nil || depth < 0 || depth > maxHistoryDepth
+	if m.historyDepthError {
+		m.errorMessage = fmt.Sprintf("Enter a number from 0 to %d", maxHistoryDepth)
+		return m, nil
+	}
+
+	key, ok := m.currentKey()
+	if !ok {
+		return m, nil
+	}
+
+	if err := SetHistoryDepth(m.vaultPath, depth, key); err != nil {
+		m.errorMessage = fmt.Sprintf("Failed to save history depth: %v", err)
+		return m, nil
+	}
+
+	if err := m.reloadEntries(key); err != nil {
+		m.setError(fmt.Sprintf("Failed to read file: %v", err))
+		return m, nil
+	}
+
+	m.goBack()
+	m.errorMessage = ""
+	m.statusMessage = fmt.Sprintf("Records keep %d previous versions", depth)
+	return m, nil
+}
+
+// Show add record form
+func (m *model) openAddRecordForm() (tea.Model, tea.Cmd) {
+	if !m.writable() {
+		return m, nil
+	}
+
+	m.dbTitleInput = createDbTitleInput()
+	m.dbUsernameInput = createDbUsernameInput()
+	m.dbPasswordInput = createDbPasswordInput()
+	m.dbURLInput = createDbURLInput()
+	m.dbOTPInput = createDbOTPInput()
+	m.breachWarnedPassword = ""
+	m.dbTitleInput.Focus()
+	m.dbPasswordInput.Blur()
+	m.statusMessage = ""
+	m.state = stateAddRecordForm
+	return m, nil
+}
+
+// Copy the current one-time code of the record under the table cursor
+func (m *model) copySelectedOTP() (tea.Model, tea.Cmd) {
+	selectedIndex := m.table.Cursor()
+	if selectedIndex >= len(m.entries) || m.entries[selectedIndex].OTP == "" {
+		m.errorMessage = "Record has no 2FA secret"
+		return m, nil
+	}
+
+	otp, err := ParseOTP(m.entries[selectedIndex].OTP)
+	if err != nil {
+		m.errorMessage = fmt.Sprintf("Invalid 2FA secret: %v", err)
+		return m, nil
+	}
+
+	code, _ := otp.Code(time.Now())
+
+	if otp.Type == OTPTypeHOTP {
+		// The counter has to be saved before the code is used, or the next copy repeats it
+		if !m.writable() {
+			return m, nil
+		}
+
+		key, ok := m.currentKey()
+		if !ok {
+			return m, nil
+		}
+
+		if err := IncrementHOTPCounter(m.vaultPath, selectedIndex, key); err != nil {
+			m.setError(fmt.Sprintf("Failed to update HOTP counter: %v", err))
+			return m, nil
+		}
+		if err := m.reloadEntries(key); err != nil {
+			m.setError(fmt.Sprintf("Failed to read file: %v", err))
+			return m, nil
+		}
+	}
+
+	copyToClipboard(code)
+	m.errorMessage = ""
+	m.statusMessage = "Code copied"
+	return m, nil
+}
+
+// Code and time left of the record under the table cursor
+func (m model) selectedOTPStatus() string {
+	selectedIndex := m.table.Cursor()
+	if selectedIndex >= len(m.entries) || m.entries[selectedIndex].OTP == "" {
+		return ""
+	}
+
+	otp, err := ParseOTP(m.entries[selectedIndex].OTP)
+	if err != nil {
+		return ""
+	}
+
+	code, remaining := otp.Code(time.Now())
+	if otp.Type == OTPTypeHOTP {
+		return fmt.Sprintf("2FA %s  counter %d", code, otp.Counter)
 	}
 
-	return m, cmd
+	seconds := int(remaining.Seconds())
+	filled := seconds * 10 / otp.Period
+	return fmt.Sprintf("2FA %s  %s%s %2ds", code, strings.Repeat("█", filled), strings.Repeat("░", 10-filled), seconds)
 }
 
-// Handle DbView keys
-func (m *model) handleDbViewKeys(key string) (tea.Model, tea.Cmd) {
-	switch key {
-	case "a":
-		m.dbTitleInput = createDbTitleInput()
-		m.dbPasswordInput = createDbPasswordInput()
-		m.dbTitleInput.Focus()
-		m.dbPasswordInput.Blur()
-		m.state = stateAddRecordForm
+// Delete the record under the table cursor
+func (m *model) deleteSelectedRecord() (tea.Model, tea.Cmd) {
+	if !m.writable() {
 		return m, nil
-	case "d":
-		if len(m.dbData) > 0 {
-			selectedIndex := m.table.Cursor()
-			if selectedIndex < len(m.dbData) {
-				err := RemoveFromPasswordFile(ReadConfigFile().DBsFolder, m.fileChoice, selectedIndex)
-				if err != nil {
-					m.setError(fmt.Sprintf("Failed to remove record: %v", err))
-					return m, nil
-				}
-
-				keyInterface, exists := GlobalStore.Get("key")
-				if !exists {
-					m.setError("Key not found")
-					return m, nil
-				}
-
-				key, ok := keyInterface.([]byte)
-				if !ok {
-					m.setError("Invalid key type")
-					return m, nil
-				}
+	}
 
-				data, err := ReadPasswordFile(m.fileChoice, key)
-				if err != nil {
-					m.setError(fmt.Sprintf("Failed to read file: %v", err))
-					return m, nil
-				}
+	if len(m.dbData) == 0 {
+		return m, nil
+	}
 
-				m.dbData = data
-				m.updateTable()
-				m.errorMessage = ""
-			}
-		}
+	selectedIndex := m.table.Cursor()
+	if selectedIndex >= len(m.dbData) {
 		return m, nil
-	case "left":
-		m.activeButton--
-		if m.activeButton < 0 {
-			m.activeButton = 1
-		}
+	}
+
+	key, ok := m.currentKey()
+	if !ok {
 		return m, nil
-	case "right":
-		m.activeButton++
-		if m.activeButton > 1 {
-			m.activeButton = 0
-		}
+	}
+
+	err := RemoveFromPasswordFile(m.vaultPath, selectedIndex, key)
+	if err != nil {
+		m.setError(fmt.Sprintf("Failed to remove record: %v", err))
 		return m, nil
-	case "enter":
-		switch m.activeButton {
-		case 0: // Add
-			m.dbTitleInput = createDbTitleInput()
-			m.dbPasswordInput = createDbPasswordInput()
-			m.dbTitleInput.Focus()
-			m.dbPasswordInput.Blur()
-			m.state = stateAddRecordForm
-		case 1: // Delete
-			if len(m.dbData) > 0 {
-				selectedIndex := m.table.Cursor()
-				if selectedIndex < len(m.dbData) {
-					err := RemoveFromPasswordFile(ReadConfigFile().DBsFolder, m.fileChoice, selectedIndex)
-					if err != nil {
-						m.setError(fmt.Sprintf("Failed to remove record: %v", err))
-						return m, nil
-					}
-
-					keyInterface, exists := GlobalStore.Get("key")
-					if !exists {
-						m.setError("Key not found")
-						return m, nil
-					}
-
-					key, ok := keyInterface.([]byte)
-					if !ok {
-						m.setError("Invalid key type")
-						return m, nil
-					}
-
-					data, err := ReadPasswordFile(m.fileChoice, key)
-					if err != nil {
-						m.setError(fmt.Sprintf("Failed to read file: %v", err))
-						return m, nil
-					}
-
-					m.dbData = data
-					m.updateTable()
-					m.errorMessage = ""
-				}
-			}
-		}
+	}
+
+	if err := m.reloadEntries(key); err != nil {
+		m.setError(fmt.Sprintf("Failed to read file: %v", err))
 		return m, nil
 	}
+
+	m.errorMessage = ""
 	return m, nil
 }
 
@@ -818,12 +3222,30 @@ func (m model) renderInputWithError(input textinput.Model, hasError bool, label
 	return inputRow
 }
 
+// Render a new password input with its estimated strength below
+func (m model) renderPasswordInputWithError(input textinput.Model, hasError bool, label string) string {
+	inputRow := m.renderInputWithError(input, hasError, label)
+	if input.Value() == "" || input.Value() != m.strengthPassword {
+		return inputRow
+	}
+
+	result := m.strength
+	meterStyle := strengthStyles[result.Score]
+	meter := meterStyle.Render(strings.Repeat("█", result.Score+1) + strings.Repeat("░", 4-result.Score))
+
+	strength := fmt.Sprintf("%s %s, cracked in %s", meter, strengthNames[result.Score], result.CrackTime)
+	if result.Warning != "" {
+		strength += "\n" + meterStyle.Render(result.Warning)
+	}
+
+	return inputRow + "\n" + lipgloss.JoinHorizontal(lipgloss.Top, labelStyle.Render(""), strength)
+}
+
 // Render buttons
 func (m model) renderButtons() string {
-	buttons := []string{"Add", "Delete"}
 	var renderedButtons []string
 
-	for i, button := range buttons {
+	for i, button := range dbViewButtons {
 		if i == m.activeButton {
 			renderedButtons = append(renderedButtons, activeButtonStyle.Render(button))
 		} else {
@@ -840,7 +3262,11 @@ func (m model) View() string {
 
 	switch m.state {
 	case stateMainMenu:
-		listContent := listStyle.Render(m.list.View()) +
+		var statusContent string
+		if m.statusMessage != "" {
+			statusContent = "\n" + statusMessageStyle.Render(m.statusMessage)
+		}
+		listContent := listStyle.Render(m.list.View()) + statusContent +
 			"\n\n(Use ↑/↓ to navigate, Enter to select)"
 		content = m.centerContent(listContent)
 
@@ -851,6 +3277,9 @@ func (m model) View() string {
 
 	case statePasswordInput:
 		passwordField := m.renderInputWithError(m.passwordInput, m.passwordInputError, "Password")
+		if m.keyFileRequired {
+			passwordField += "\n\n" + m.renderInputWithError(m.keyFileInput, m.keyFileInputError, "Key file")
+		}
 		var errorContent string
 		if m.errorMessage != "" {
 			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
@@ -862,28 +3291,35 @@ func (m model) View() string {
 			errorContent,
 		)
 		styledForm := formStyle.Render(formContent) +
-			"\n\n(Enter to submit, Esc to cancel)"
+			"\n\n(Enter to submit, Ctrl+R to unlock with recovery shares, Esc to cancel)"
 		content = m.centerContent(styledForm)
 
 	case stateAddDbForm:
 		titleField := m.renderInputWithError(m.titleInput, m.titleInputError, "Title")
-		passwordField := m.renderInputWithError(m.passwordInput, m.passwordInputError, "Password")
+		passwordField := m.renderPasswordInputWithError(m.passwordInput, m.passwordInputError, "Password")
+		keyFileField := m.renderInputWithError(m.keyFileInput, m.keyFileInputError, "Key file")
 		var errorContent string
 		if m.errorMessage != "" {
 			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		} else if m.statusMessage != "" {
+			errorContent = "\n" + statusMessageStyle.Render(m.statusMessage)
 		}
 
 		formContent := fmt.Sprintf(
-			"Add New Database\n\n%s\n\n%s%s\n\n(Tab to switch fields)",
+			"Add New Database\n\n%s\n\n%s\n\n%s%s\n\n(Tab to switch fields, Ctrl+G to generate passphrase)",
 			titleField,
 			passwordField,
+			keyFileField,
 			errorContent,
 		)
 		styledForm := formStyle.Render(formContent)
 		content = m.centerContent(styledForm)
 
 	case stateDbView:
-		tableTitle := tableTitleStyle.Render(m.fileChoice)
+		tableTitle := tableTitleStyle.Render(m.vaultTitle())
+		if m.rollbackWarning != "" {
+			tableTitle = errorMessageStyle.Render(m.rollbackWarning) + "\n" + tableTitle
+		}
 		tableContent := m.table.View()
 		tableWithStyle := tableStyle.Render(tableContent)
 		centeredTable := tableContainerStyle.Render(tableWithStyle)
@@ -892,12 +3328,20 @@ func (m model) View() string {
 		var errorContent string
 		if m.errorMessage != "" {
 			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		} else if m.statusMessage != "" {
+			errorContent = "\n" + statusMessageStyle.Render(m.statusMessage)
+		}
+
+		var otpContent string
+		if otpStatus := m.selectedOTPStatus(); otpStatus != "" {
+			otpContent = "\n" + otpStatus
 		}
 
 		viewContent := fmt.Sprintf(
-			"%s\n%s\n%s%s\n(↑/↓ navigate, ←/→ select, Enter execute)",
+			"%s\n%s%s\n%s%s\n(↑/↓ navigate, ←/→ select, Enter execute, e edit, h history, c copy 2FA code, p breach scan, s share, i import bundle, l lock)",
 			tableTitle,
 			centeredTable,
+			otpContent,
 			buttons,
 			errorContent,
 		)
@@ -905,21 +3349,389 @@ func (m model) View() string {
 
 	case stateAddRecordForm:
 		titleField := m.renderInputWithError(m.dbTitleInput, m.dbTitleInputError, "Title")
-		passwordField := m.renderInputWithError(m.dbPasswordInput, m.dbPasswordInputError, "Password")
+		usernameField := m.renderInputWithError(m.dbUsernameInput, false, "Username")
+		passwordField := m.renderPasswordInputWithError(m.dbPasswordInput, m.dbPasswordInputError, "Password")
+		urlField := m.renderInputWithError(m.dbURLInput, false, "URL")
+		otpField := m.renderInputWithError(m.dbOTPInput, m.dbOTPInputError, "2FA")
 		var errorContent string
 		if m.errorMessage != "" {
 			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
 		}
 
 		formContent := fmt.Sprintf(
-			"Add New Record\n\n%s\n\n%s%s\n\n(Tab to switch fields)",
+			"Add New Record\n\n%s\n\n%s\n\n%s\n\n%s\n\n%s%s\n\n(Tab to switch fields, Ctrl+G to generate password)",
 			titleField,
+			usernameField,
 			passwordField,
+			urlField,
+			otpField,
 			errorContent,
 		)
 		styledForm := formStyle.Render(formContent)
 		content = m.centerContent(styledForm)
 
+	case stateEditRecordForm:
+		titleField := m.renderInputWithError(m.dbTitleInput, m.dbTitleInputError, "Title")
+		usernameField := m.renderInputWithError(m.dbUsernameInput, false, "Username")
+		passwordField := m.renderPasswordInputWithError(m.dbPasswordInput, m.dbPasswordInputError, "Password")
+		urlField := m.renderInputWithError(m.dbURLInput, false, "URL")
+		otpField := m.renderInputWithError(m.dbOTPInput, m.dbOTPInputError, "2FA")
+		var errorContent string
+		if m.errorMessage != "" {
+			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		}
+
+		formContent := fmt.Sprintf(
+			"Edit Record\n\n%s\n\n%s\n\n%s\n\n%s\n\n%s%s\n\n(Tab to switch fields, Ctrl+G to generate password)",
+			titleField,
+			usernameField,
+			passwordField,
+			urlField,
+			otpField,
+			errorContent,
+		)
+		styledForm := formStyle.Render(formContent) +
+			"\n\n(Enter to save, the previous values go to history, Esc to cancel)"
+		content = m.centerContent(styledForm)
+
+	case stateHistory:
+		var messageContent string
+		if m.errorMessage != "" {
+			messageContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		} else if m.statusMessage != "" {
+			messageContent = "\n" + statusMessageStyle.Render(m.statusMessage)
+		}
+
+		historyContent := "No previous versions"
+		if selectedIndex := m.table.Cursor(); selectedIndex < len(m.entries) {
+			entry := m.entries[selectedIndex]
+			if versionIndex := m.historyList.Index(); versionIndex >= 0 && versionIndex < len(entry.History) {
+				historyContent = listStyle.Render(m.historyList.View()) + "\n" +
+					formStyle.Render("Selected version → current\n\n"+renderHistoryDiff(entry.History[versionIndex], entry))
+			}
+		}
+
+		listContent := tableTitleStyle.Render(m.vaultTitle()) + "\n" +
+			historyContent + messageContent +
+			"\n\n(↑/↓ select version, Enter: restore it, b: back)"
+		content = m.centerContent(listContent)
+
+	case stateHistoryDepthForm:
+		depthField := m.renderInputWithError(m.historyDepthInput, m.historyDepthError, "Versions")
+		var errorContent string
+		if m.errorMessage != "" {
+			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		}
+
+		formContent := fmt.Sprintf(
+			"Record History Depth\n%s\n\n%s%s\n\n(0 keeps no history)",
+			m.fileChoice,
+			depthField,
+			errorContent,
+		)
+		styledForm := formStyle.Render(formContent) +
+			"\n\n(Enter to submit, Esc to cancel)"
+		content = m.centerContent(styledForm)
+
+	case stateManageMenu:
+		var statusContent string
+		if m.statusMessage != "" {
+			statusContent = "\n" + statusMessageStyle.Render(m.statusMessage)
+		}
+		if m.rollbackWarning != "" {
+			statusContent = "\n" + errorMessageStyle.Render(m.rollbackWarning) + statusContent
+		}
+		listContent := tableTitleStyle.Render(m.vaultTitle()) + "\n" +
+			listStyle.Render(m.manageList.View()) + statusContent +
+			"\n\n(Enter to select, b: back, m: main menu)"
+		content = m.centerContent(listContent)
+
+	case stateChangePasswordForm:
+		newPasswordField := m.renderPasswordInputWithError(m.newPasswordInput, m.newPasswordError, "New")
+		confirmPasswordField := m.renderInputWithError(m.confirmPasswordInput, m.confirmPasswordError, "Repeat")
+		var errorContent string
+		if m.errorMessage != "" {
+			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		}
+
+		if m.keyFileRequired {
+			confirmPasswordField += "\n\n" + m.renderInputWithError(m.keyFileInput, m.keyFileInputError, "Key file")
+		}
+
+		formTitle := "Change Master Password"
+		if m.forcePasswordChange {
+			formTitle = "Unlocked with recovery shares, set a new master password"
+		}
+
+		formContent := fmt.Sprintf(
+			"%s\n%s\n\n%s\n\n%s\n\n%s%s\n\n(Tab to switch fields, Ctrl+G to generate passphrase)",
+			formTitle,
+			m.fileChoice,
+			rekeyLossText(m.rekeyLoss),
+			newPasswordField,
+			confirmPasswordField,
+			errorContent,
+		)
+		styledForm := formStyle.Render(formContent) +
+			"\n\n(Enter to submit, Esc to cancel)"
+		content = m.centerContent(styledForm)
+
+	case stateKeySlots:
+		var errorContent string
+		if m.errorMessage != "" {
+			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		}
+		listContent := tableTitleStyle.Render(m.fileChoice) + "\n" +
+			listStyle.Render(m.slotList.View()) + errorContent +
+			"\n\n(a: add password, r: add recovery key, d: remove, b: back)"
+		content = m.centerContent(listContent)
+
+	case stateMembers:
+		var messageContent string
+		if m.errorMessage != "" {
+			messageContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		} else if m.statusMessage != "" {
+			messageContent = "\n" + statusMessageStyle.Render(m.statusMessage)
+		}
+
+		identityContent := "No local identity, press g to generate one"
+		if m.localRecipient != "" {
+			identityContent = "Your public key:\n" + m.localRecipient
+		}
+
+		listContent := tableTitleStyle.Render(m.fileChoice) + "\n" +
+			listStyle.Render(m.slotList.View()) + "\n" + identityContent + messageContent +
+			"\n\n(a: add member, s: add yourself, d: remove, g: generate identity, y: copy public key, b: back)"
+		content = m.centerContent(listContent)
+
+	case stateBackups:
+		var errorContent string
+		if m.errorMessage != "" {
+			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		}
+
+		backupsContent := listStyle.Render(m.backupList.View())
+		if len(m.backups) == 0 {
+			backupsContent = "No backups yet, one is taken before every save"
+		}
+
+		listContent := tableTitleStyle.Render(m.vaultTitle()) + "\n" +
+			backupsContent + errorContent +
+			"\n\n(Enter: restore records, b: back)"
+		content = m.centerContent(listContent)
+
+	case stateAddMemberForm:
+		nameField := m.renderInputWithError(m.slotLabelInput, m.slotLabelError, "Name")
+		keyField := m.renderInputWithError(m.ageKeysInput, m.ageKeysError, "Key")
+		var errorContent string
+		if m.errorMessage != "" {
+			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		}
+
+		formContent := fmt.Sprintf(
+			"Add Team Member\n%s\n\n%s\n\n%s%s\n\n(Tab to switch fields)",
+			m.fileChoice,
+			nameField,
+			keyField,
+			errorContent,
+		)
+		styledForm := formStyle.Render(formContent) +
+			"\n\n(Enter to submit, Esc to cancel)"
+		content = m.centerContent(styledForm)
+
+	case stateRemoveMemberForm:
+		passwordField := m.renderInputWithError(m.passwordInput, m.passwordInputError, "Password")
+		if m.keyFileRequired {
+			passwordField += "\n\n" + m.renderInputWithError(m.keyFileInput, m.keyFileInputError, "Key file")
+		}
+		var errorContent string
+		if m.errorMessage != "" {
+			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		}
+
+		formContent := fmt.Sprintf(
+			"Remove Team Member\n%s\n\n%s\n\n%s%s",
+			m.fileChoice,
+			"The vault gets a new key. Member slots are kept, password slots\nonly if the password below opens them. Recovery keys are replaced\nand recovery shares stop working.",
+			passwordField,
+			errorContent,
+		)
+		styledForm := formStyle.Render(formContent) +
+			"\n\n(Enter to remove, Esc to cancel)"
+		content = m.centerContent(styledForm)
+
+	case stateAddSlotForm:
+		labelField := m.renderInputWithError(m.slotLabelInput, m.slotLabelError, "Label")
+		newPasswordField := m.renderPasswordInputWithError(m.newPasswordInput, m.newPasswordError, "Password")
+		confirmPasswordField := m.renderInputWithError(m.confirmPasswordInput, m.confirmPasswordError, "Repeat")
+		keyFileField := m.renderInputWithError(m.keyFileInput, m.keyFileInputError, "Key file")
+		var errorContent string
+		if m.errorMessage != "" {
+			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		}
+
+		formContent := fmt.Sprintf(
+			"Add Password Slot\n\n%s\n\n%s\n\n%s\n\n%s%s\n\n(Tab to switch fields, Ctrl+G to generate passphrase)",
+			labelField,
+			newPasswordField,
+			confirmPasswordField,
+			keyFileField,
+			errorContent,
+		)
+		styledForm := formStyle.Render(formContent) +
+			"\n\n(Enter to submit, Esc to cancel)"
+		content = m.centerContent(styledForm)
+
+	case stateSharesForm:
+		countField := m.renderInputWithError(m.shareCountInput, m.shareCountError, "Shares")
+		thresholdField := m.renderInputWithError(m.shareThresholdInput, m.shareThresholdError, "Needed")
+		var errorContent string
+		if m.errorMessage != "" {
+			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		}
+
+		formContent := fmt.Sprintf(
+			"Split Vault Key Into Recovery Shares\n%s\n\n%s\n\n%s%s\n\n(Tab to switch fields)",
+			m.fileChoice,
+			countField,
+			thresholdField,
+			errorContent,
+		)
+		styledForm := formStyle.Render(formContent) +
+			"\n\n(Enter to submit, Esc to cancel)"
+		content = m.centerContent(styledForm)
+
+	case stateShares:
+		var renderedShares []string
+		for _, share := range m.shares {
+			renderedShares = append(renderedShares, activeButtonStyle.Render(share))
+		}
+
+		sharesContent := fmt.Sprintf(
+			"Recovery shares\n\n%s\n\n%s",
+			strings.Join(renderedShares, "\n"),
+			"Hand each share to a different person. They are not stored\nand not shown again, and stop working if the vault is re-keyed.",
+		)
+		styledForm := formStyle.Render(sharesContent) +
+			"\n\n(Enter to continue)"
+		content = m.centerContent(styledForm)
+
+	case stateExportForm:
+		pathField := m.renderInputWithError(m.agePathInput, m.agePathError, "File")
+		keysField := m.renderInputWithError(m.ageKeysInput, m.ageKeysError, "To")
+		passphraseField := m.renderPasswordInputWithError(m.agePassphraseInput, m.agePassphraseError, "Phrase")
+		var errorContent string
+		if m.errorMessage != "" {
+			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		}
+
+		formContent := fmt.Sprintf(
+			"Export With age\n%s\n\n%s\n\n%s\n\n%s%s\n\n(Tab to switch fields)",
+			m.fileChoice,
+			pathField,
+			keysField,
+			passphraseField,
+			errorContent,
+		)
+		styledForm := formStyle.Render(formContent) +
+			"\n\n(Enter to export, Esc to cancel)"
+		content = m.centerContent(styledForm)
+
+	case stateImportForm:
+		pathField := m.renderInputWithError(m.agePathInput, m.agePathError, "File")
+		keysField := m.renderInputWithError(m.ageKeysInput, m.ageKeysError, "Identity")
+		passphraseField := m.renderInputWithError(m.agePassphraseInput, m.agePassphraseError, "Phrase")
+		var errorContent string
+		if m.errorMessage != "" {
+			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		}
+
+		formTitle := "Import With age\n" + m.fileChoice
+		if m.importNew {
+			formTitle = fmt.Sprintf(
+				"Import Into New Database\n\n%s\n\n%s",
+				m.renderInputWithError(m.titleInput, m.titleInputError, "Title"),
+				m.renderPasswordInputWithError(m.passwordInput, m.passwordInputError, "Password"),
+			)
+		}
+
+		fieldsHelp := "(Tab to switch fields)"
+		if m.importNew {
+			fieldsHelp = "(Tab to switch fields, Ctrl+G to generate passphrase)"
+		}
+
+		formContent := fmt.Sprintf(
+			"%s\n\n%s\n\n%s\n\n%s%s\n\n%s",
+			formTitle,
+			pathField,
+			keysField,
+			passphraseField,
+			errorContent,
+			fieldsHelp,
+		)
+		styledForm := formStyle.Render(formContent) +
+			"\n\n(Enter to import, Esc to cancel)"
+		content = m.centerContent(styledForm)
+
+	case stateShareEntryForm, stateImportBundleForm:
+		pathField := m.renderInputWithError(m.agePathInput, m.agePathError, "File")
+		keysField := m.renderInputWithError(m.ageKeysInput, m.ageKeysError, "Identity")
+		passphraseField := m.renderInputWithError(m.agePassphraseInput, m.agePassphraseError, "Phrase")
+		formTitle := "Import Record Bundle"
+		submitHelp := "(Enter to import, Esc to cancel)"
+		if m.state == stateShareEntryForm {
+			keysField = m.renderInputWithError(m.ageKeysInput, m.ageKeysError, "To")
+			passphraseField = m.renderPasswordInputWithError(m.agePassphraseInput, m.agePassphraseError, "Phrase")
+			formTitle = "Share Record " + m.entries[m.table.Cursor()].Title
+			submitHelp = "(Enter to share, Esc to cancel)"
+		}
+		var errorContent string
+		if m.errorMessage != "" {
+			errorContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		}
+
+		formContent := fmt.Sprintf(
+			"%s\n%s\n\n%s\n\n%s\n\n%s%s\n\n(Tab to switch fields)",
+			formTitle,
+			m.fileChoice,
+			pathField,
+			keysField,
+			passphraseField,
+			errorContent,
+		)
+		styledForm := formStyle.Render(formContent) +
+			"\n\n" + submitHelp
+		content = m.centerContent(styledForm)
+
+	case stateShareInput:
+		shareField := m.renderInputWithError(m.shareInput, m.shareInputError, "Share")
+		var messageContent string
+		if m.errorMessage != "" {
+			messageContent = "\n" + errorMessageStyle.Render(m.errorMessage)
+		} else if m.statusMessage != "" {
+			messageContent = "\n" + statusMessageStyle.Render(m.statusMessage)
+		}
+
+		formContent := fmt.Sprintf(
+			"Unlock With Recovery Shares\n%s\n\n%s%s\n\n(Enter one share at a time)",
+			m.fileChoice,
+			shareField,
+			messageContent,
+		)
+		styledForm := formStyle.Render(formContent) +
+			"\n\n(Enter to add share, Esc to cancel)"
+		content = m.centerContent(styledForm)
+
+	case stateRecoveryCode:
+		codeContent := fmt.Sprintf(
+			"Recovery key\n\n%s\n\n%s",
+			activeButtonStyle.Render(m.recoveryCode),
+			"Write it down and keep it safe, it opens the vault\nlike the master password and is not shown again.",
+		)
+		styledForm := formStyle.Render(codeContent) +
+			"\n\n(Enter to continue)"
+		content = m.centerContent(styledForm)
+
 	case stateKeyBindings:
 		bindingsContent := bindingsStyle.Render(getKeyBindingsText())
 		content = m.centerContent(bindingsContent)
@@ -956,24 +3768,64 @@ File Selection:
   /            - Filter
   Enter        - Select
 
+Manage DBs:
+  ↑/↓          - Navigate actions
+  Enter        - Select action
+
+Key Slots:
+  a            - Add password slot
+  r            - Add recovery key
+  d            - Remove slot
+
+Backups:
+  Enter        - Restore records from the selected backup
+
+Record History:
+  ↑/↓          - Select version
+  Enter        - Restore selected version
+
+Team Members:
+  a            - Add member by public key
+  s            - Add yourself
+  d            - Remove member and re-key
+  g            - Generate local identity
+  y            - Copy your public key
+
+Password Input:
+  Ctrl+R       - Unlock with recovery shares
+  Enter        - Empty password unlocks a team vault with your identity
+
 Database View:
   ↑/↓          - Navigate rows
   ←/→          - Select action
   Enter        - Execute
   a            - Add record
+  e            - Edit record
+  h            - Record history
   d            - Delete record
+  c            - Copy current 2FA code
+  p            - Check passwords against breach file
+  s            - Share record as an encrypted bundle
+  i            - Import a record bundle
+  l            - Lock vault
 
 Forms:
   Tab          - Switch fields
+  Ctrl+G       - Generate record password or master passphrase
   Enter        - Submit
   Esc          - Cancel
 `
 }
 
 func main() {
+	config := ReadConfigFile()
+	SetBackupPolicy(config.BackupDir, config.BackupCount)
+
 	m := initialModel()
 
-	if _, err := tea.NewProgram(m).Run(); err != nil {
+	_, err := tea.NewProgram(m).Run()
+	Session.Wipe()
+	if err != nil {
 		fmt.Println("Error:", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OTPTypeTOTP = "totp"
+	OTPTypeHOTP = "hotp"
+
+	defaultOTPDigits = 6
+	defaultOTPPeriod = 30
+)
+
+// One-time password settings of an entry, from an otpauth:// URI or a bare base32 secret
+type OTP struct {
+	Type      string
+	Secret    []byte
+	Algorithm string
+	Digits    int
+	Period    int    // TOTP step in seconds
+	Counter   uint64 // HOTP moving factor
+}
+
+func decodeOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(secret))
+	secret = strings.TrimRight(secret, "=")
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid base32 secret")
+	}
+	return key, nil
+}
+
+// A bare secret is a TOTP with the usual SHA1, 6 digits and 30 seconds
+func ParseOTP(text string) (OTP, error) {
+	text = strings.TrimSpace(text)
+	otp := OTP{
+		Type:      OTPTypeTOTP,
+		Algorithm: "SHA1",
+		Digits:    defaultOTPDigits,
+		Period:    defaultOTPPeriod,
+	}
+
+	if !strings.HasPrefix(strings.ToLower(text), "otpauth://") {
+		secret, err := decodeOTPSecret(text)
+		if err != nil {
+			return OTP{}, err
+		}
+		otp.Secret = secret
+		return otp, nil
+	}
+
+	u, err := url.Parse(text)
+	if err != nil {
+		return OTP{}, fmt.Errorf("invalid otpauth URI: %v", err)
+	}
+
+	otp.Type = strings.ToLower(u.Host)
+	if otp.Type != OTPTypeTOTP && otp.Type != OTPTypeHOTP {
+		return OTP{}, fmt.Errorf("unsupported OTP type %q", u.Host)
+	}
+
+	query := u.Query()
+	otp.Secret, err = decodeOTPSecret(query.Get("secret"))
+	if err != nil {
+		return OTP{}, err
+	}
+
+	if algorithm := query.Get("algorithm"); algorithm != "" {
+		otp.Algorithm = strings.ToUpper(algorithm)
+		if otp.Algorithm != "SHA1" && otp.Algorithm != "SHA256" && otp.Algorithm != "SHA512" {
+			return OTP{}, fmt.Errorf("unsupported OTP algorithm %q", algorithm)
+		}
+	}
+
+	if digits := query.Get("digits"); digits != "" {
+		otp.Digits, err = strconv.Atoi(digits)
+		if err != nil || otp.Digits < 6 || otp.Digits > 10 {
+			return OTP{}, fmt.Errorf("invalid OTP digits %q", digits)
+		}
+	}
+
+	if period := query.Get("period"); period != "" {
+		otp.Period, err = strconv.Atoi(period)
+		if err != nil || otp.Period < 1 {
+			return OTP{}, fmt.Errorf("invalid OTP period %q", period)
+		}
+	}
+
+	if otp.Type == OTPTypeHOTP {
+		otp.Counter, err = strconv.ParseUint(query.Get("counter"), 10, 64)
+		if err != nil {
+			return OTP{}, fmt.Errorf("HOTP URI needs a counter")
+		}
+	}
+
+	return otp, nil
+}
+
+func (otp OTP) hash() func() hash.Hash {
+	switch otp.Algorithm {
+	case "SHA256":
+		return sha256.New
+	case "SHA512":
+		return sha512.New
+	default:
+		return sha1.New
+	}
+}
+
+// RFC 4226 dynamic truncation of HMAC(secret, counter)
+func (otp OTP) HOTP(counter uint64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], counter)
+
+	mac := hmac.New(otp.hash(), otp.Secret)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint64(1)
+	for i := 0; i < otp.Digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", otp.Digits, uint64(value)%modulo)
+}
+
+// Current code and, for TOTP, the time it stays valid (RFC 6238)
+func (otp OTP) Code(now time.Time) (string, time.Duration) {
+	if otp.Type == OTPTypeHOTP {
+		return otp.HOTP(otp.Counter), 0
+	}
+
+	period := int64(otp.Period)
+	step := now.Unix() / period
+	remaining := time.Duration(period-now.Unix()%period) * time.Second
+	return otp.HOTP(uint64(step)), remaining
+}
+
+// HOTP codes are used up, so the stored counter moves on each time one is taken
+func IncrementHOTPCounter(path string, selectedIndex int, key []byte) error {
+	return updateVault(path, key, func(_ *PasswordFile, db *Database) error {
+		if selectedIndex < 0 || selectedIndex >= len(db.Entries) {
+			return fmt.Errorf("record not found")
+		}
+		entry := &db.Entries[selectedIndex]
+
+		otp, err := ParseOTP(entry.OTP)
+		if err != nil {
+			return err
+		}
+		if otp.Type != OTPTypeHOTP {
+			return fmt.Errorf("record has no HOTP secret")
+		}
+
+		u, err := url.Parse(strings.TrimSpace(entry.OTP))
+		if err != nil {
+			return err
+		}
+		query := u.Query()
+		query.Set("counter", strconv.FormatUint(otp.Counter+1, 10))
+		u.RawQuery = query.Encode()
+		entry.OTP = u.String()
+		return nil
+	})
+}
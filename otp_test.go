@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/base32"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func otpURI(kind, secret, algorithm string, digits int, extra string) string {
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte(secret))
+	return fmt.Sprintf("otpauth://%s/test?secret=%s&algorithm=%s&digits=%d%s", kind, encoded, algorithm, digits, extra)
+}
+
+// RFC 4226 appendix D
+func TestHOTPVectors(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	for counter, code := range want {
+		otp, err := ParseOTP(otpURI(OTPTypeHOTP, "12345678901234567890", "SHA1", 6, fmt.Sprintf("&counter=%d", counter)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := otp.Code(time.Now()); got != code {
+			t.Errorf("counter %d: got %s, want %s", counter, got, code)
+		}
+	}
+}
+
+// RFC 6238 appendix B
+func TestTOTPVectors(t *testing.T) {
+	secrets := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+
+	tests := []struct {
+		unix      int64
+		algorithm string
+		code      string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1111111111, "SHA1", "14050471"},
+		{1111111111, "SHA256", "67062674"},
+		{1111111111, "SHA512", "99943326"},
+		{1234567890, "SHA1", "89005924"},
+		{1234567890, "SHA256", "91819424"},
+		{1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"},
+		{2000000000, "SHA256", "90698825"},
+		{2000000000, "SHA512", "38618901"},
+		{20000000000, "SHA1", "65353130"},
+		{20000000000, "SHA256", "77737706"},
+		{20000000000, "SHA512", "47863826"},
+	}
+
+	for _, tt := range tests {
+		otp, err := ParseOTP(otpURI(OTPTypeTOTP, secrets[tt.algorithm], tt.algorithm, 8, "&period=30"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, remaining := otp.Code(time.Unix(tt.unix, 0))
+		if code != tt.code {
+			t.Errorf("%s at %d: got %s, want %s", tt.algorithm, tt.unix, code, tt.code)
+		}
+		if want := time.Duration(30-tt.unix%30) * time.Second; remaining != want {
+			t.Errorf("%s at %d: %v remaining, want %v", tt.algorithm, tt.unix, remaining, want)
+		}
+	}
+}
+
+// Taking an HOTP code moves the counter in the saved vault to the next code
+func TestIncrementHOTPCounter(t *testing.T) {
+	path, key := newTestVault(t)
+	uri := otpURI(OTPTypeHOTP, "12345678901234567890", "SHA1", 6, "&counter=4")
+	if err := AddToPasswordFile(path, Entry{Title: "Bank", Password: "secret", OTP: uri}, key); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, index := range []int{-1, 2} {
+		if err := IncrementHOTPCounter(path, index, key); err == nil {
+			t.Errorf("incremented the counter of record %d", index)
+		}
+	}
+	if err := IncrementHOTPCounter(path, 0, key); err == nil {
+		t.Error("incremented the counter of a record without HOTP")
+	}
+
+	for _, want := range []uint64{5, 6} {
+		if err := IncrementHOTPCounter(path, 1, key); err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := ReadPasswordFile(path, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		otp, err := ParseOTP(entries[1].OTP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if otp.Counter != want {
+			t.Errorf("counter %d, want %d", otp.Counter, want)
+		}
+	}
+}
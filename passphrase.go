@@ -0,0 +1,84 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// EFF large wordlist, https://www.eff.org/deeplinks/2016/07/new-wordlists-random-passphrases
+// (CC BY 3.0 US). One "dice rolls<TAB>word" pair per line
+//
+//go:embed wordlists/eff_large_wordlist.txt
+var effLargeWordlist string
+
+var effWords = sync.OnceValue(func() []string {
+	var words []string
+	for _, line := range strings.Split(effLargeWordlist, "\n") {
+		if _, word, ok := strings.Cut(strings.TrimSpace(line), "\t"); ok {
+			words = append(words, word)
+		}
+	}
+	return words
+})
+
+// Passphrase generator settings, the [passphrase] table in the app config
+type PassphrasePolicy struct {
+	Words      int    `koanf:"words"`
+	Separator  string `koanf:"separator"`
+	Capitalize bool   `koanf:"capitalize"`
+	// Appends a random digit to one random word
+	AddDigit bool `koanf:"add_digit"`
+}
+
+func DefaultPassphrasePolicy() PassphrasePolicy {
+	return PassphrasePolicy{
+		Words:     6,
+		Separator: "-",
+	}
+}
+
+// Returns the passphrase and its entropy in bits. Only the random choices count,
+// capitalizing every word and the separator add nothing
+func GeneratePassphrase(policy PassphrasePolicy) (string, float64, error) {
+	if policy.Words < 1 || policy.Words > 20 {
+		return "", 0, fmt.Errorf("word count must be between 1 and 20")
+	}
+
+	wordlist := effWords()
+	words := make([]string, policy.Words)
+	for i := range words {
+		index, err := randomIndex(len(wordlist))
+		if err != nil {
+			return "", 0, err
+		}
+
+		word := wordlist[index]
+		if policy.Capitalize {
+			runes := []rune(word)
+			runes[0] = unicode.ToUpper(runes[0])
+			word = string(runes)
+		}
+		words[i] = word
+	}
+
+	entropy := float64(policy.Words) * math.Log2(float64(len(wordlist)))
+
+	if policy.AddDigit {
+		position, err := randomIndex(len(words))
+		if err != nil {
+			return "", 0, err
+		}
+		digit, err := randomIndex(10)
+		if err != nil {
+			return "", 0, err
+		}
+		words[position] += fmt.Sprint(digit)
+		entropy += math.Log2(10) + math.Log2(float64(len(words)))
+	}
+
+	return strings.Join(words, policy.Separator), entropy, nil
+}
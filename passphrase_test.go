@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestGeneratePassphrase(t *testing.T) {
+	// 7776 words, log2(6^5) bits each
+	wordBits := 5 * math.Log2(6)
+
+	tests := []struct {
+		name    string
+		policy  PassphrasePolicy
+		ok      bool
+		entropy float64
+	}{
+		// Some words have a hyphen, so the words are split at a separator none of them has
+		{"six words", PassphrasePolicy{Words: 6, Separator: " "}, true, 6 * wordBits},
+		{"one word", PassphrasePolicy{Words: 1, Separator: " "}, true, wordBits},
+		// Capitalizing every word is no random choice
+		{"capitalized", PassphrasePolicy{Words: 4, Separator: ".", Capitalize: true}, true, 4 * wordBits},
+		// The digit and the word it goes to are both random
+		{"digit", PassphrasePolicy{Words: 5, Separator: "_", AddDigit: true}, true, 5*wordBits + math.Log2(10) + math.Log2(5)},
+		{"no words", PassphrasePolicy{Words: 0}, false, 0},
+		{"too many words", PassphrasePolicy{Words: 21}, false, 0},
+	}
+
+	if n := len(effWords()); n != 7776 {
+		t.Fatalf("wordlist has %d words, want 7776", n)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passphrase, entropy, err := GeneratePassphrase(tt.policy)
+			if (err == nil) != tt.ok {
+				t.Fatalf("generate: %v, want ok %v", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+
+			if math.Abs(entropy-tt.entropy) > 1e-9 {
+				t.Errorf("entropy %.2f bits, want %.2f", entropy, tt.entropy)
+			}
+
+			words := strings.Split(passphrase, tt.policy.Separator)
+			if len(words) != tt.policy.Words {
+				t.Fatalf("%q has %d words, want %d", passphrase, len(words), tt.policy.Words)
+			}
+			digits := 0
+			for _, word := range words {
+				if tt.policy.Capitalize && !unicode.IsUpper([]rune(word)[0]) {
+					t.Errorf("%q is not capitalized", word)
+				}
+				if strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+					digits++
+				}
+			}
+			if want := map[bool]int{true: 1}[tt.policy.AddDigit]; digits != want {
+				t.Errorf("%q has %d words with a digit, want %d", passphrase, digits, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// One step of the vault file format, from the schema version at its index to the next one.
+// Steps run on the decrypted file, so they cannot change how the data key is obtained:
+// files without key slots get them on unlock, since that needs the master password,
+// and ciphers and key derivation are upgraded by every save
+type schemaMigration struct {
+	Description string
+	Migrate     func(passwordFile *PasswordFile, db *Database) error
+}
+
+// Ordered, append only. testdata/schema has a fixture of every historical layout, all with
+// the master password "test", schema0_key_file.json also with the key file next to it
+var schemaMigrations = []schemaMigration{
+	{"unversioned files", migrateUnversioned},
+	// Only the MAC changes, the next save writes it over the whole file
+	{"MAC over known fields", func(*PasswordFile, *Database) error { return nil }},
+}
+
+// First schema whose MAC covers every field of the file, see fileMAC
+const schemaFileMAC = 2
+
+// Schema version of files written now
+var currentSchema = len(schemaMigrations)
+
+// Files from before schema versions. Legacy cleartext databases were decrypted on load and move
+// into the payload, their KDF parameters go to the header and the legacy meta fields are dropped
+func migrateUnversioned(passwordFile *PasswordFile, db *Database) error {
+	if len(passwordFile.Header.Slots) == 0 && passwordFile.Header.KDF == nil {
+		kdf := kdfParams(passwordFile)
+		passwordFile.Header.KDF = &kdf
+	}
+
+	if passwordFile.Database != nil {
+		passwordFile.Database = nil
+		// Their verifier was in Meta, the next save makes a header one
+		passwordFile.Header.Verifier = ""
+	}
+
+	db.Meta.Hash = ""
+	db.Meta.Salt = ""
+	db.Meta.Verifier = ""
+
+	if db.Entries == nil {
+		db.Entries = []Entry{}
+	}
+	return nil
+}
+
+// Brings a loaded file up to currentSchema. Only memory changes, the file is rewritten by the next save
+func migrateVault(passwordFile *PasswordFile, db *Database) error {
+	for passwordFile.Schema < currentSchema {
+		step := schemaMigrations[passwordFile.Schema]
+		if err := step.Migrate(passwordFile, db); err != nil {
+			return fmt.Errorf("failed to migrate %s: %v", step.Description, err)
+		}
+		passwordFile.Schema++
+	}
+	return nil
+}
+
+// Copies a vault file in an older schema before it is first overwritten in the current one.
+// These copies are kept apart from the rotated backups and never removed
+func backupBeforeMigrate(path string) error {
+	if !fileExists(path) {
+		return nil
+	}
+
+	passwordFile, err := loadPasswordFile(path)
+	if err != nil {
+		return err
+	}
+	if passwordFile.Schema >= currentSchema {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dir := vaultBackups.Dir
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(path), ".backups")
+	}
+	dir = filepath.Join(dir, "migrations")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%sschema%d.%s.bak", backupPrefix(path), passwordFile.Schema, time.Now().UTC().Format(backupTimeFormat))
+	return writeFileAtomic(filepath.Join(dir, name), data, 0600)
+}
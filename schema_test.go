@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaFixtures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		keyFile string
+		schema  int
+		// Refused with an integrity error instead of opened
+		rejected bool
+	}{
+		{fixture: "schema0_title_hash.json"},
+		{fixture: "schema0_aead_entries.json"},
+		{fixture: "schema0_header_kdf.json"},
+		{fixture: "schema0_meta_verifier.json"},
+		{fixture: "schema0_payload.json"},
+		{fixture: "schema0_key_file.json", keyFile: "schema0_key_file.key"},
+		// Key slots without a MAC look the same as a file whose MAC was stripped
+		{fixture: "schema0_key_slots.json", rejected: true},
+		{fixture: "schema0_mac.json"},
+		{fixture: "schema0_subkeys.json"},
+		{fixture: "schema1.json", schema: 1},
+		{fixture: "schema2.json", schema: 2},
+	}
+
+	// Every fixture on disk has to be in the table
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "schema", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := map[string]bool{}
+	for _, tt := range tests {
+		listed[tt.fixture] = true
+	}
+	for _, fixture := range fixtures {
+		if !listed[filepath.Base(fixture)] {
+			t.Errorf("fixture %s is not tested", filepath.Base(fixture))
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			dir := t.TempDir()
+			SetBackupPolicy(filepath.Join(dir, ".backups"), defaultBackupCount)
+			t.Cleanup(func() { SetBackupPolicy("", 0) })
+
+			data, err := os.ReadFile(filepath.Join("testdata", "schema", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "vault.json")
+			if err := os.WriteFile(path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			keyFile := ""
+			if tt.keyFile != "" {
+				keyFile = filepath.Join("testdata", "schema", tt.keyFile)
+			}
+
+			isOk, key, _, err := UnlockPasswordFile(path, "test", keyFile)
+			if tt.rejected {
+				if err == nil || !strings.Contains(err.Error(), "integrity") {
+					t.Fatalf("unlock: ok %v, %v, want an integrity error", isOk, err)
+				}
+				return
+			}
+			if err != nil || !isOk {
+				t.Fatalf("unlock: ok %v, %v", isOk, err)
+			}
+
+			entries, err := ReadPasswordFile(path, key)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if len(entries) != 1 || entries[0].Title != "GitHub" || entries[0].Password != "hunter2" {
+				t.Fatalf("unexpected entries %+v", entries)
+			}
+
+			err = updateVault(path, key, func(*PasswordFile, *Database) error { return nil })
+			if err != nil {
+				t.Fatalf("save: %v", err)
+			}
+
+			reopened, err := ReadPasswordFile(path, key)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			if !reflect.DeepEqual(reopened, entries) {
+				t.Errorf("entries changed by the save\nbefore %+v\nafter  %+v", entries, reopened)
+			}
+
+			passwordFile, err := loadPasswordFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if passwordFile.Schema != currentSchema {
+				t.Errorf("schema %d, want %d", passwordFile.Schema, currentSchema)
+			}
+
+			copies, err := filepath.Glob(filepath.Join(dir, ".backups", "migrations", "vault.json.*.bak"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantCopies := 1
+			if tt.schema == currentSchema {
+				wantCopies = 0
+			}
+			if len(copies) != wantCopies {
+				t.Fatalf("%d copies under migrations, want %d", len(copies), wantCopies)
+			}
+			if wantCopies == 1 {
+				copied, err := os.ReadFile(copies[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(copied, data) {
+					t.Error("migration copy differs from the original file")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import "sync"
+
+// Vault key of the unlocked session. Held in locked memory outside the Go heap where the
+// platform allows it, so it is never swapped to disk, and zeroed as soon as the vault is closed
+type SessionKey struct {
+	key    []byte
+	locked bool
+	mu     sync.RWMutex
+}
+
+// Global instance
+var Session = &SessionKey{}
+
+// Copies key into session memory, wiping the previous one
+func (s *SessionKey) Set(key []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buf, locked := allocSecure(len(key))
+	copy(buf, key)
+	s.release()
+	s.key = buf
+	s.locked = locked
+}
+
+// The returned slice is only valid until the session is wiped, do not keep it around
+func (s *SessionKey) Key() ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.key, s.key != nil
+}
+
+// Zeroes and releases the key, safe to call on an empty session
+func (s *SessionKey) Wipe() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.release()
+}
+
+func (s *SessionKey) release() {
+	if s.key == nil {
+		return
+	}
+	wipeBytes(s.key)
+	freeSecure(s.key, s.locked)
+	s.key = nil
+	s.locked = false
+}
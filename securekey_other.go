@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+// No memory locking here, the key is only wiped
+func allocSecure(size int) ([]byte, bool) {
+	return make([]byte, size), false
+}
+
+func freeSecure(buf []byte, locked bool) {}
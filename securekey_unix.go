@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// Anonymous mapping locked into RAM, falls back to a heap slice if mlock is not permitted
+func allocSecure(size int) ([]byte, bool) {
+	buf, err := unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return make([]byte, size), false
+	}
+
+	if err := unix.Mlock(buf); err != nil {
+		_ = unix.Munmap(buf)
+		return make([]byte, size), false
+	}
+
+	return buf, true
+}
+
+func freeSecure(buf []byte, locked bool) {
+	if !locked {
+		return
+	}
+	_ = unix.Munlock(buf)
+	_ = unix.Munmap(buf)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Shamir's secret sharing over GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1.
+// Every byte of the secret is the constant term of its own random polynomial of degree threshold-1
+
+// Checksum bytes appended to every printed share to catch typos
+const shareChecksumSize = 2
+
+type Share struct {
+	Threshold int
+	X         byte
+	Y         []byte
+}
+
+func gfMul(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 == 1 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+// a^254 is the multiplicative inverse in GF(2^8)
+func gfInv(a byte) byte {
+	result := byte(1)
+	for i := 0; i < 254; i++ {
+		result = gfMul(result, a)
+	}
+	return result
+}
+
+// Splits secret into n shares, any threshold of them reconstruct it
+func SplitSecret(secret []byte, n, threshold int) ([]Share, error) {
+	if threshold < 2 || n < threshold || n > 255 {
+		return nil, fmt.Errorf("invalid share parameters: need 2 <= threshold <= shares <= 255")
+	}
+
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{Threshold: threshold, X: byte(i + 1), Y: make([]byte, len(secret))}
+	}
+
+	coefficients := make([]byte, threshold)
+	defer wipeBytes(coefficients)
+
+	for b, secretByte := range secret {
+		coefficients[0] = secretByte
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+
+		for i := range shares {
+			// Horner's scheme
+			var y byte
+			for c := threshold - 1; c >= 0; c-- {
+				y = gfMul(y, shares[i].X) ^ coefficients[c]
+			}
+			shares[i].Y[b] = y
+		}
+	}
+
+	return shares, nil
+}
+
+// Lagrange interpolation at x = 0
+func CombineShares(shares []Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no shares")
+	}
+
+	threshold := shares[0].Threshold
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("need %d shares, got %d", threshold, len(shares))
+	}
+	shares = shares[:threshold]
+
+	size := len(shares[0].Y)
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if share.Threshold != threshold || len(share.Y) != size {
+			return nil, fmt.Errorf("shares belong to different splits")
+		}
+		if share.X == 0 || seen[share.X] {
+			return nil, fmt.Errorf("duplicated share %d", share.X)
+		}
+		seen[share.X] = true
+	}
+
+	secret := make([]byte, size)
+	for i, share := range shares {
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = gfMul(basis, gfMul(other.X, gfInv(other.X^share.X)))
+			}
+		}
+
+		for b := range secret {
+			secret[b] ^= gfMul(share.Y[b], basis)
+		}
+	}
+
+	return secret, nil
+}
+
+func shareChecksum(share Share) []byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d-%d-", share.Threshold, share.X)
+	h.Write(share.Y)
+	return h.Sum(nil)[:shareChecksumSize]
+}
+
+// Printed as threshold-index-data, the data in dash separated base32 groups of four
+func (share Share) String() string {
+	data := append(append([]byte{}, share.Y...), shareChecksum(share)...)
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(data)
+
+	var groups []string
+	for i := 0; i < len(encoded); i += 4 {
+		groups = append(groups, encoded[i:min(i+4, len(encoded))])
+	}
+
+	return fmt.Sprintf("%d-%d-%s", share.Threshold, share.X, strings.Join(groups, "-"))
+}
+
+// Accepts a printed share with any case and spacing
+func ParseShare(text string) (Share, error) {
+	parts := strings.SplitN(strings.TrimSpace(text), "-", 3)
+	if len(parts) != 3 {
+		return Share{}, fmt.Errorf("invalid share format")
+	}
+
+	threshold, err := strconv.Atoi(parts[0])
+	if err != nil || threshold < 2 || threshold > 255 {
+		return Share{}, fmt.Errorf("invalid share threshold")
+	}
+
+	x, err := strconv.Atoi(parts[1])
+	if err != nil || x < 1 || x > 255 {
+		return Share{}, fmt.Errorf("invalid share index")
+	}
+
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(parts[2]))
+	data, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(normalized)
+	if err != nil || len(data) <= shareChecksumSize {
+		return Share{}, fmt.Errorf("invalid share data")
+	}
+
+	share := Share{
+		Threshold: threshold,
+		X:         byte(x),
+		Y:         data[:len(data)-shareChecksumSize],
+	}
+
+	if string(shareChecksum(share)) != string(data[len(data)-shareChecksumSize:]) {
+		return Share{}, fmt.Errorf("share checksum mismatch, check for typos")
+	}
+
+	return share, nil
+}
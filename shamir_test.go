@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestGFInverse(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if product := gfMul(byte(a), gfInv(byte(a))); product != 1 {
+			t.Fatalf("%d * inverse = %d", a, product)
+		}
+	}
+}
+
+// Calls visit with every k element subset of shares
+func shareSubsets(shares []Share, k int, visit func([]Share)) {
+	var walk func(start int, chosen []Share)
+	walk = func(start int, chosen []Share) {
+		if len(chosen) == k {
+			visit(append([]Share{}, chosen...))
+			return
+		}
+		for i := start; i < len(shares); i++ {
+			walk(i+1, append(chosen, shares[i]))
+		}
+	}
+	walk(0, nil)
+}
+
+func TestShamirThresholds(t *testing.T) {
+	settings := []struct{ threshold, n int }{
+		{2, 2}, {2, 3}, {3, 5}, {4, 6}, {5, 8},
+	}
+
+	for _, s := range settings {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			t.Fatal(err)
+		}
+
+		shares, err := SplitSecret(secret, s.n, s.threshold)
+		if err != nil {
+			t.Fatalf("%d of %d: %v", s.threshold, s.n, err)
+		}
+
+		shareSubsets(shares, s.threshold, func(subset []Share) {
+			recovered, err := CombineShares(subset)
+			if err != nil || !bytes.Equal(recovered, secret) {
+				t.Errorf("%d of %d: shares %v do not recover the secret (%v)", s.threshold, s.n, shareIndexes(subset), err)
+			}
+
+			// Order does not matter
+			reversed := make([]Share, len(subset))
+			for i, share := range subset {
+				reversed[len(subset)-1-i] = share
+			}
+			if recovered, err := CombineShares(reversed); err != nil || !bytes.Equal(recovered, secret) {
+				t.Errorf("%d of %d: reversed shares %v do not recover the secret", s.threshold, s.n, shareIndexes(subset))
+			}
+		})
+
+		shareSubsets(shares, s.threshold-1, func(subset []Share) {
+			if _, err := CombineShares(subset); err == nil {
+				t.Errorf("%d of %d: %d shares accepted", s.threshold, s.n, len(subset))
+			}
+
+			// Even when they claim a lower threshold, too few shares give another secret
+			lowered := make([]Share, len(subset))
+			for i, share := range subset {
+				lowered[i] = Share{Threshold: len(subset), X: share.X, Y: share.Y}
+			}
+			if len(lowered) < 2 {
+				return
+			}
+			if recovered, err := CombineShares(lowered); err == nil && bytes.Equal(recovered, secret) {
+				t.Errorf("%d of %d: shares %v recover the secret below the threshold", s.threshold, s.n, shareIndexes(subset))
+			}
+		})
+	}
+}
+
+func shareIndexes(shares []Share) []byte {
+	var indexes []byte
+	for _, share := range shares {
+		indexes = append(indexes, share.X)
+	}
+	return indexes
+}
+
+func TestCombineSharesRejectsBadIndexes(t *testing.T) {
+	shares, err := SplitSecret([]byte("data key"), 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	duplicated := []Share{shares[0], shares[1], shares[1]}
+	if _, err := CombineShares(duplicated); err == nil {
+		t.Error("duplicated share index accepted")
+	}
+
+	zero := shares[2]
+	zero.X = 0
+	if _, err := CombineShares([]Share{shares[0], shares[1], zero}); err == nil {
+		t.Error("zero share index accepted")
+	}
+
+	if _, err := ParseShare("3-0-" + shares[0].String()[4:]); err == nil {
+		t.Error("zero share index parsed")
+	}
+}
+
+func TestSplitSecretParameters(t *testing.T) {
+	for _, p := range []struct{ n, threshold int }{{3, 1}, {2, 3}, {256, 2}, {0, 0}} {
+		if _, err := SplitSecret([]byte("secret"), p.n, p.threshold); err == nil {
+			t.Errorf("%d of %d accepted", p.threshold, p.n)
+		}
+	}
+}
+
+func TestShareRoundTrip(t *testing.T) {
+	shares, err := SplitSecret([]byte("data key"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, share := range shares {
+		parsed, err := ParseShare(share.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Threshold != share.Threshold || parsed.X != share.X || !bytes.Equal(parsed.Y, share.Y) {
+			t.Errorf("share %d changed by printing", share.X)
+		}
+	}
+}
@@ -0,0 +1,649 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Frequency ranked lists from the zxcvbn project (MIT), most common first
+//
+//go:embed wordlists/common_passwords.txt
+var commonPasswordsList string
+
+//go:embed wordlists/english_words.txt
+var englishWordsList string
+
+// Guesses per second of an offline attack on a slow hash
+const crackGuessesPerSecond = 1e4
+
+// Years near this one are the likeliest in dates
+var referenceYear = time.Now().Year()
+
+type rankedDictionary struct {
+	name  string
+	ranks map[string]int
+}
+
+var strengthDictionaries = sync.OnceValue(func() []rankedDictionary {
+	load := func(name, list string) rankedDictionary {
+		ranks := make(map[string]int)
+		for i, word := range strings.Split(list, "\n") {
+			if word != "" {
+				ranks[word] = i + 1
+			}
+		}
+		return rankedDictionary{name: name, ranks: ranks}
+	}
+
+	return []rankedDictionary{
+		load("passwords", commonPasswordsList),
+		load("english", englishWordsList),
+	}
+})
+
+// Common substitutions, undone before the dictionary lookup
+var l33tTable = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '9': 'g',
+	'1': 'i', '!': 'i', '|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+// US keyboard rows, each shifted by half a key against the one above
+var keyboardRows = []struct {
+	keys, shifted string
+	offset        int
+}{
+	{"`1234567890-=", "~!@#$%^&*()_+", 0},
+	{"qwertyuiop[]\\", "QWERTYUIOP{}|", 3},
+	{"asdfghjkl;'", "ASDFGHJKL:\"", 4},
+	{"zxcvbnm,./", "ZXCVBNM<>?", 5},
+}
+
+type keyPosition struct {
+	row, x  int
+	shifted bool
+}
+
+var keyboardPositions = sync.OnceValue(func() map[rune]keyPosition {
+	positions := make(map[rune]keyPosition)
+	for row, keys := range keyboardRows {
+		shifted := []rune(keys.shifted)
+		for i, key := range []rune(keys.keys) {
+			positions[key] = keyPosition{row: row, x: keys.offset + 2*i}
+			positions[shifted[i]] = keyPosition{row: row, x: keys.offset + 2*i, shifted: true}
+		}
+	}
+	return positions
+})
+
+// Part of the password matched by a pattern, i and j inclusive rune indexes
+type strengthMatch struct {
+	i, j    int
+	guesses float64
+	warning string
+}
+
+type StrengthResult struct {
+	Score     int // 0 to 4
+	Guesses   float64
+	CrackTime string
+	Warning   string
+}
+
+// zxcvbn style estimate: the password is covered by the least guessable sequence of
+// dictionary words, keyboard patterns, repeats, sequences, dates and brute force
+func EstimateStrength(password string) StrengthResult {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return StrengthResult{CrackTime: "instant"}
+	}
+
+	var matches []strengthMatch
+	matches = append(matches, dictionaryMatches(runes)...)
+	matches = append(matches, spatialMatches(runes)...)
+	matches = append(matches, repeatMatches(runes)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, dateMatches(runes)...)
+
+	// best[k] is log10 of the fewest guesses covering the first k runes
+	n := len(runes)
+	best := make([]float64, n+1)
+	chosen := make([]*strengthMatch, n+1)
+	for k := 1; k <= n; k++ {
+		best[k] = best[k-1] + 1 // brute force, one of ten per character
+		chosen[k] = nil
+		for index := range matches {
+			match := &matches[index]
+			if match.j != k-1 {
+				continue
+			}
+			if guesses := best[match.i] + math.Log10(match.guesses); guesses < best[k] {
+				best[k] = guesses
+				chosen[k] = match
+			}
+		}
+	}
+
+	result := StrengthResult{Guesses: math.Pow(10, best[n])}
+
+	for k := n; k > 0; {
+		if chosen[k] == nil {
+			k--
+			continue
+		}
+		if chosen[k].warning != "" {
+			result.Warning = chosen[k].warning
+		}
+		k = chosen[k].i
+	}
+
+	switch {
+	case result.Guesses < 1e3:
+		result.Score = 0
+	case result.Guesses < 1e6:
+		result.Score = 1
+	case result.Guesses < 1e8:
+		result.Score = 2
+	case result.Guesses < 1e10:
+		result.Score = 3
+	default:
+		result.Score = 4
+	}
+
+	if result.Score >= 3 {
+		result.Warning = ""
+	}
+	result.CrackTime = formatCrackTime(result.Guesses / crackGuessesPerSecond)
+	return result
+}
+
+func binomial(n, k int) float64 {
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result = result * float64(n-k+i) / float64(i)
+	}
+	return result
+}
+
+// Ways to capitalize a word of this length, "Word" and "WORD" count as two
+func uppercaseVariations(word []rune) float64 {
+	upper, lower := 0, 0
+	for _, r := range word {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+
+	if upper == 0 {
+		return 1
+	}
+	if lower == 0 || (upper == 1 && unicode.IsUpper(word[0])) || (upper == 1 && unicode.IsUpper(word[len(word)-1])) {
+		return 2
+	}
+
+	variations := 0.0
+	for i := 1; i <= min(upper, lower); i++ {
+		variations += binomial(upper+lower, i)
+	}
+	return variations
+}
+
+func reverseRunes(runes []rune) []rune {
+	reversed := make([]rune, len(runes))
+	for i, r := range runes {
+		reversed[len(runes)-1-i] = r
+	}
+	return reversed
+}
+
+func dictionaryMatches(runes []rune) []strengthMatch {
+	lower := []rune(strings.ToLower(string(runes)))
+
+	unleet := make([]rune, len(lower))
+	for i, r := range lower {
+		if plain, ok := l33tTable[r]; ok {
+			unleet[i] = plain
+		} else {
+			unleet[i] = r
+		}
+	}
+
+	var matches []strengthMatch
+	for i := range lower {
+		for j := i + 2; j < len(lower); j++ {
+			word := lower[i : j+1]
+			variations := uppercaseVariations(runes[i : j+1])
+
+			candidates := []struct {
+				word   string
+				factor float64
+			}{
+				{string(word), 1},
+				{string(reverseRunes(word)), 2},
+				{string(unleet[i : j+1]), 2},
+			}
+
+			for _, dictionary := range strengthDictionaries() {
+				for c, candidate := range candidates {
+					// Substitutions that change nothing are not l33t
+					if c == 2 && candidate.word == candidates[0].word {
+						continue
+					}
+
+					rank, ok := dictionary.ranks[candidate.word]
+					if !ok {
+						continue
+					}
+
+					warning := "Common words are easy to guess"
+					if dictionary.name == "passwords" {
+						warning = "This is similar to a commonly used password"
+						if i == 0 && j == len(lower)-1 {
+							warning = "This is a commonly used password"
+						}
+					}
+
+					matches = append(matches, strengthMatch{
+						i:       i,
+						j:       j,
+						guesses: float64(rank) * variations * candidate.factor,
+						warning: warning,
+					})
+				}
+			}
+		}
+	}
+
+	return append(matches, misspelledMatches(runes, lower, unleet)...)
+}
+
+// Words one edit away from a dictionary word, like "troubador". Only whole runs of letters
+// after undoing substitutions are checked, a word with a digit or symbol appended is the common case
+func misspelledMatches(runes, lower, unleet []rune) []strengthMatch {
+	const (
+		minLength = 5
+		maxLength = 24
+	)
+
+	var matches []strengthMatch
+	for i := 0; i < len(unleet); {
+		if !unicode.IsLetter(unleet[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j+1 < len(unleet) && unicode.IsLetter(unleet[j+1]) {
+			j++
+		}
+
+		if length := j - i + 1; length >= minLength && length <= maxLength {
+			factor := 2.0
+			if string(unleet[i:j+1]) != string(lower[i:j+1]) {
+				factor *= 2
+			}
+
+			for _, dictionary := range strengthDictionaries() {
+				// Exact words are matched already
+				if _, ok := dictionary.ranks[string(unleet[i:j+1])]; ok {
+					continue
+				}
+
+				best := 0
+				for _, edit := range singleEdits(unleet[i : j+1]) {
+					if rank, ok := dictionary.ranks[edit]; ok && (best == 0 || rank < best) {
+						best = rank
+					}
+				}
+				if best == 0 {
+					continue
+				}
+
+				warning := "Misspelled words are easy to guess"
+				if dictionary.name == "passwords" {
+					warning = "This is similar to a commonly used password"
+				}
+
+				matches = append(matches, strengthMatch{
+					i:       i,
+					j:       j,
+					guesses: float64(best) * uppercaseVariations(runes[i:j+1]) * factor,
+					warning: warning,
+				})
+			}
+		}
+		i = j + 1
+	}
+	return matches
+}
+
+// Every string one deletion, insertion, substitution or swap of neighbours away from word
+func singleEdits(word []rune) []string {
+	var edits []string
+	for k := 0; k <= len(word); k++ {
+		for r := 'a'; r <= 'z'; r++ {
+			edits = append(edits, string(word[:k])+string(r)+string(word[k:]))
+		}
+		if k == len(word) {
+			break
+		}
+
+		edits = append(edits, string(word[:k])+string(word[k+1:]))
+		for r := 'a'; r <= 'z'; r++ {
+			if r != word[k] {
+				edits = append(edits, string(word[:k])+string(r)+string(word[k+1:]))
+			}
+		}
+		if k+1 < len(word) {
+			swapped := append([]rune{}, word...)
+			swapped[k], swapped[k+1] = swapped[k+1], swapped[k]
+			edits = append(edits, string(swapped))
+		}
+	}
+	return edits
+}
+
+// Runs of neighbouring keys like "qwerty" or "zxcvbn", longer runs and turns cost more
+func spatialMatches(runes []rune) []strengthMatch {
+	const (
+		startingKeys  = 94
+		averageDegree = 4.6
+	)
+
+	positions := keyboardPositions()
+	adjacent := func(a, b rune) (int, int, bool) {
+		pa, okA := positions[a]
+		pb, okB := positions[b]
+		if !okA || !okB {
+			return 0, 0, false
+		}
+		dr, dx := pb.row-pa.row, pb.x-pa.x
+		if (dr == 0 && (dx == 2 || dx == -2)) || ((dr == 1 || dr == -1) && (dx == 1 || dx == -1)) {
+			return dr, dx, true
+		}
+		return 0, 0, false
+	}
+
+	var matches []strengthMatch
+	for i := 0; i < len(runes)-2; {
+		j := i
+		turns := 0
+		shifted := positions[runes[i]].shifted
+		lastDr, lastDx := 0, 0
+		for j+1 < len(runes) {
+			dr, dx, ok := adjacent(runes[j], runes[j+1])
+			if !ok {
+				break
+			}
+			if j == i || dr != lastDr || dx != lastDx {
+				turns++
+			}
+			lastDr, lastDx = dr, dx
+			shifted = shifted || positions[runes[j+1]].shifted
+			j++
+		}
+
+		if length := j - i + 1; length >= 3 {
+			guesses := 0.0
+			for t := 1; t <= min(turns, length-1); t++ {
+				guesses += binomial(length-1, t-1) * startingKeys * math.Pow(averageDegree, float64(t))
+			}
+			if shifted {
+				guesses *= 2
+			}
+			matches = append(matches, strengthMatch{i: i, j: j, guesses: guesses, warning: "Keyboard patterns are easy to guess"})
+			i = j
+			continue
+		}
+		i++
+	}
+	return matches
+}
+
+// The same character or block several times, like "aaa" or "abcabc". Only the longest
+// repeat from each position is kept and every distinct block is estimated once
+func repeatMatches(runes []rune) []strengthMatch {
+	blockGuesses := make(map[string]float64)
+
+	var matches []strengthMatch
+	for i := range runes {
+		bestSize, bestCount := 0, 0
+		for size := 1; i+2*size <= len(runes); size++ {
+			block := string(runes[i : i+size])
+			count := 1
+			for i+(count+1)*size <= len(runes) && string(runes[i+count*size:i+(count+1)*size]) == block {
+				count++
+			}
+
+			if count < 2 || (size == 1 && count < 3) {
+				continue
+			}
+			if count*size > bestCount*bestSize {
+				bestSize, bestCount = size, count
+			}
+		}
+
+		if bestCount == 0 {
+			continue
+		}
+
+		block := string(runes[i : i+bestSize])
+		guesses, ok := blockGuesses[block]
+		if !ok {
+			guesses = EstimateStrength(block).Guesses
+			blockGuesses[block] = guesses
+		}
+
+		warning := "Repeats like \"abcabc\" are easy to guess"
+		if bestSize == 1 {
+			warning = "Repeats like \"aaa\" are easy to guess"
+		}
+
+		matches = append(matches, strengthMatch{
+			i:       i,
+			j:       i + bestCount*bestSize - 1,
+			guesses: guesses * float64(bestCount),
+			warning: warning,
+		})
+	}
+	return matches
+}
+
+// Letters or digits with a constant step, like "abcd", "6543" or "aceg"
+func sequenceMatches(runes []rune) []strengthMatch {
+	sameClass := func(a, b rune) bool {
+		return (unicode.IsLower(a) && unicode.IsLower(b)) ||
+			(unicode.IsUpper(a) && unicode.IsUpper(b)) ||
+			(unicode.IsDigit(a) && unicode.IsDigit(b))
+	}
+
+	var matches []strengthMatch
+	for i := 0; i < len(runes)-2; {
+		delta := runes[i+1] - runes[i]
+		if delta == 0 || delta > 5 || delta < -5 || !sameClass(runes[i], runes[i+1]) {
+			i++
+			continue
+		}
+
+		j := i + 1
+		for j+1 < len(runes) && runes[j+1]-runes[j] == delta && sameClass(runes[j], runes[j+1]) {
+			j++
+		}
+
+		if length := j - i + 1; length >= 3 {
+			first := runes[i]
+			base := 26.0
+			switch {
+			case strings.ContainsRune("aAzZ019", first):
+				base = 4
+			case unicode.IsDigit(first):
+				base = 10
+			}
+			if delta < 0 {
+				base *= 2
+			}
+
+			matches = append(matches, strengthMatch{
+				i:       i,
+				j:       j,
+				guesses: base * float64(length),
+				warning: "Sequences like abc or 6543 are easy to guess",
+			})
+			i = j
+			continue
+		}
+		i++
+	}
+	return matches
+}
+
+func validDate(day, month, year int) (int, bool) {
+	if year < 100 {
+		// Two digit years, 50 and later are last century
+		if year >= 50 {
+			year += 1900
+		} else {
+			year += 2000
+		}
+	}
+	if year < 1000 || year > 2050 || month < 1 || month > 12 || day < 1 || day > 31 {
+		return 0, false
+	}
+	return year, true
+}
+
+// Guesses for a date, most people pick recent years
+func dateGuesses(year int, separator bool) float64 {
+	guesses := 365 * math.Max(math.Abs(float64(year-referenceYear)), 20)
+	if separator {
+		guesses *= 4
+	}
+	return guesses
+}
+
+// Years like 1987 and dates like 19.08.1987, 2001-05-12 or 120587
+func dateMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+
+	for i := range runes {
+		for j := i + 3; j < len(runes) && j-i < 10; j++ {
+			token := string(runes[i : j+1])
+
+			// Bare years
+			if len(token) == 4 && isDigits(token) {
+				year, _ := strconv.Atoi(token)
+				if year >= 1900 && year <= 2050 {
+					matches = append(matches, strengthMatch{
+						i:       i,
+						j:       j,
+						guesses: math.Max(math.Abs(float64(year-referenceYear)), 20),
+						warning: "Recent years are easy to guess",
+					})
+				}
+			}
+
+			if year, separator, ok := parseDateToken(token); ok {
+				matches = append(matches, strengthMatch{
+					i:       i,
+					j:       j,
+					guesses: dateGuesses(year, separator),
+					warning: "Dates are often easy to guess",
+				})
+			}
+		}
+	}
+	return matches
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Three numbers split by one repeated separator, or 4 to 8 digits read in day, month, year orders
+func parseDateToken(token string) (year int, separator bool, ok bool) {
+	var parts [][]string
+
+	if isDigits(token) {
+		if len(token) > 8 {
+			return 0, false, false
+		}
+		for a := 1; a <= 4 && a < len(token); a++ {
+			for b := 1; b <= 2 && a+b < len(token); b++ {
+				if rest := len(token) - a - b; rest <= 4 {
+					parts = append(parts, []string{token[:a], token[a : a+b], token[a+b:]})
+				}
+			}
+		}
+	} else {
+		for _, sep := range []string{"/", "\\", "_", ".", "-", " "} {
+			if fields := strings.Split(token, sep); len(fields) == 3 && isDigits(fields[0]) && isDigits(fields[1]) && isDigits(fields[2]) {
+				parts = append(parts, fields)
+				separator = true
+			}
+		}
+	}
+
+	for _, p := range parts {
+		var numbers [3]int
+		for i, field := range p {
+			if len(field) > 4 {
+				return 0, false, false
+			}
+			numbers[i], _ = strconv.Atoi(field)
+		}
+
+		// day month year, month day year, year month day
+		for _, order := range [][3]int{{0, 1, 2}, {1, 0, 2}, {2, 1, 0}} {
+			if len(p[order[2]]) == 3 || len(p[order[2]]) == 1 {
+				continue
+			}
+			if y, valid := validDate(numbers[order[0]], numbers[order[1]], numbers[order[2]]); valid {
+				return y, separator, true
+			}
+		}
+	}
+	return 0, false, false
+}
+
+func formatCrackTime(seconds float64) string {
+	units := []struct {
+		name    string
+		seconds float64
+	}{
+		{"year", 365 * 24 * 3600},
+		{"month", 30 * 24 * 3600},
+		{"day", 24 * 3600},
+		{"hour", 3600},
+		{"minute", 60},
+		{"second", 1},
+	}
+
+	if seconds < 1 {
+		return "less than a second"
+	}
+	if seconds >= 100*units[0].seconds {
+		return "centuries"
+	}
+
+	for _, unit := range units {
+		if seconds >= unit.seconds {
+			count := int(math.Round(seconds / unit.seconds))
+			if count == 1 {
+				return "1 " + unit.name
+			}
+			return fmt.Sprintf("%d %ss", count, unit.name)
+		}
+	}
+	return "less than a second"
+}
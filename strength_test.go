@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestEstimateStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		minScore int
+		maxScore int
+	}{
+		{"", 0, 0},
+		{"password", 0, 0},
+		{"P@ssw0rd1", 0, 0},
+		{"qwertyuiop", 0, 1},
+		{"abcdefgh", 0, 1},
+		{"19.08.1987", 0, 1},
+		// l33t substitutions in a misspelled word with a symbol and a digit appended
+		{"Tr0ub4dor&3", 0, 2},
+		{"Tr0ub4dour&3", 0, 2},
+		{"correcthorsebatterystaple", 4, 4},
+		{"Xk9#mQ2$vL7!", 4, 4},
+	}
+
+	for _, tt := range tests {
+		result := EstimateStrength(tt.password)
+		if result.Score < tt.minScore || result.Score > tt.maxScore {
+			t.Errorf("%q: score %d, want %d to %d (%.0f guesses)", tt.password, result.Score, tt.minScore, tt.maxScore, result.Guesses)
+		}
+	}
+}
+
+func TestEstimateStrengthMisspelledWarning(t *testing.T) {
+	if result := EstimateStrength("Tr0ub4dor&3"); result.Warning == "" {
+		t.Errorf("no warning for a misspelled dictionary word, %.0f guesses", result.Guesses)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Versions of Header.KeyDerivation, how the data key is turned into the keys actually used
+const (
+	// The data key encrypts the payload and the verifier itself
+	keyDerivationDirect = 0
+	// HKDF-SHA256 expands the data key into one subkey per purpose
+	keyDerivationHKDF = 1
+
+	currentKeyDerivation = keyDerivationHKDF
+)
+
+const subkeySize = 32
+
+// Keys for each use of the vault data key, so no two features ever share one
+type Subkeys struct {
+	Entries  []byte
+	MAC      []byte
+	Verifier []byte
+	// Reserved for file attachments
+	Attachments []byte
+	// Reserved for an encrypted search index
+	SearchIndex []byte
+}
+
+func hkdfSubkey(key []byte, purpose string) ([]byte, error) {
+	return hkdf.Key(sha256.New, key, nil, "go_pass_manager v1 "+purpose, subkeySize)
+}
+
+// Expands the data key with the given Header.KeyDerivation version
+func DeriveSubkeys(key []byte, version int) (*Subkeys, error) {
+	switch version {
+	case keyDerivationDirect:
+		// Files from before subkeys only had a payload, a verifier and a MAC
+		return &Subkeys{
+			Entries:  append([]byte{}, key...),
+			Verifier: append([]byte{}, key...),
+			MAC:      keyedHash(key, fileMACLabel, nil),
+		}, nil
+	case keyDerivationHKDF:
+		subkeys := &Subkeys{}
+		for _, subkey := range []struct {
+			dst     *[]byte
+			purpose string
+		}{
+			{&subkeys.Entries, "entries"},
+			{&subkeys.MAC, "mac"},
+			{&subkeys.Verifier, "verifier"},
+			{&subkeys.Attachments, "attachments"},
+			{&subkeys.SearchIndex, "search index"},
+		} {
+			derived, err := hkdfSubkey(key, subkey.purpose)
+			if err != nil {
+				subkeys.Wipe()
+				return nil, err
+			}
+			*subkey.dst = derived
+		}
+		return subkeys, nil
+	}
+	return nil, fmt.Errorf("unsupported key derivation version %d", version)
+}
+
+func (s *Subkeys) Wipe() {
+	wipeBytes(s.Entries)
+	wipeBytes(s.MAC)
+	wipeBytes(s.Verifier)
+	wipeBytes(s.Attachments)
+	wipeBytes(s.SearchIndex)
+}
+
+// Reports whether key is the data key of the vault
+func (h Header) CheckKey(key []byte) bool {
+	subkeys, err := DeriveSubkeys(key, h.KeyDerivation)
+	if err != nil {
+		return false
+	}
+	defer subkeys.Wipe()
+
+	return CheckVerifier(h.Verifier, subkeys.Verifier)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/google/uuid"
+)
+
+// Team member slots wrap the data key with age for the member's X25519 public key
+const SlotMember = "x25519"
+
+// Reads the first X25519 identity of an age identity file
+func LoadIdentity(path string) (*age.X25519Identity, error) {
+	file, err := os.Open(expandHome(path))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, identity := range identities {
+		if x25519, ok := identity.(*age.X25519Identity); ok {
+			return x25519, nil
+		}
+	}
+	return nil, fmt.Errorf("no X25519 identity in %s", path)
+}
+
+// Creates a new identity file in the age-keygen format, never overwriting an existing one
+func GenerateIdentity(path string) (*age.X25519Identity, error) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, err
+	}
+
+	path = expandHome(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "# created: %s\n# public key: %s\n%s\n",
+		time.Now().Format(time.RFC3339), identity.Recipient(), identity)
+	if err != nil {
+		return nil, err
+	}
+	return identity, file.Sync()
+}
+
+// Reports whether any team member can open the vault with an identity
+func (h Header) HasMembers() bool {
+	for _, slot := range h.Slots {
+		if slot.Type == SlotMember {
+			return true
+		}
+	}
+	return false
+}
+
+func newMemberSlot(label, publicKey string, dataKey []byte) (KeySlot, error) {
+	recipient, err := age.ParseX25519Recipient(strings.TrimSpace(publicKey))
+	if err != nil {
+		return KeySlot{}, fmt.Errorf("invalid public key: %v", err)
+	}
+
+	var wrapped bytes.Buffer
+	writer, err := age.Encrypt(&wrapped, recipient)
+	if err != nil {
+		return KeySlot{}, err
+	}
+	if _, err := writer.Write(dataKey); err != nil {
+		return KeySlot{}, err
+	}
+	if err := writer.Close(); err != nil {
+		return KeySlot{}, err
+	}
+
+	return KeySlot{
+		ID:         uuid.NewString(),
+		Type:       SlotMember,
+		Label:      label,
+		Recipient:  recipient.String(),
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped.Bytes()),
+	}, nil
+}
+
+// Returns the data key when the slot was wrapped for identity
+func (slot KeySlot) unwrapMember(identity *age.X25519Identity) ([]byte, bool, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(slot.WrappedKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid member slot: %v", err)
+	}
+
+	reader, err := age.Decrypt(bytes.NewReader(wrapped), identity)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	dataKey, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, false, nil
+	}
+	return dataKey, true, nil
+}
+
+// Opens the vault with the member slot of identity
+func UnlockWithIdentity(filename string, identity *age.X25519Identity) (isOk bool, key []byte, slotID string, err error) {
+	header, err := ReadPasswordFileHeader(filename)
+	if err != nil {
+		return false, nil, "", err
+	}
+
+	recipient := identity.Recipient().String()
+	for _, slot := range header.Slots {
+		if slot.Type != SlotMember || slot.Recipient != recipient {
+			continue
+		}
+
+		dataKey, ok, err := slot.unwrapMember(identity)
+		if err != nil {
+			return false, nil, "", err
+		}
+		if !ok {
+			continue
+		}
+
+		if header.Verifier != "" && !header.CheckKey(dataKey) {
+			wipeBytes(dataKey)
+			return false, nil, "", fmt.Errorf("member slot does not match the vault key")
+		}
+		return verifiedUnlock(filename, dataKey, slot.ID)
+	}
+
+	return false, nil, "", nil
+}
+
+func AddMember(path string, key []byte, name, publicKey string) error {
+	return updateKeySlots(path, key, func(header *Header) error {
+		slot, err := newMemberSlot(name, publicKey, key)
+		if err != nil {
+			return err
+		}
+
+		for _, existing := range header.Slots {
+			if existing.Type == SlotMember && existing.Recipient == slot.Recipient {
+				return fmt.Errorf("%s is already a member", existing.Label)
+			}
+		}
+
+		header.Slots = append(header.Slots, slot)
+		return nil
+	})
+}
+
+// Removes a member and re-keys the vault, so a copy of the old data key is useless for new saves.
+// Password slots are rewrapped only when password opens them
+func RemoveMember(path string, key []byte, memberID, password, keyFile string) (RekeyResult, error) {
+	header, err := ReadPasswordFileHeader(path)
+	if err != nil {
+		return RekeyResult{}, err
+	}
+
+	found := false
+	for _, slot := range header.Slots {
+		if slot.ID == memberID && slot.Type == SlotMember {
+			found = true
+		}
+	}
+	if !found {
+		return RekeyResult{}, fmt.Errorf("team member not found")
+	}
+
+	return rekeyVault(path, key, func(slot KeySlot, newKey []byte, cipherName string) (KeySlot, bool, error) {
+		switch {
+		case slot.ID == memberID:
+			return KeySlot{}, false, nil
+		case slot.Type == SlotMember:
+			return rewrapMember(slot, newKey)
+		case slot.Type != SlotPassword || password == "" || (slot.KeyFile && keyFile == ""):
+			return KeySlot{}, false, nil
+		}
+
+		slotKeyFile := keyFile
+		if !slot.KeyFile {
+			slotKeyFile = ""
+		}
+		secret, err := masterSecret(password, slotKeyFile)
+		if err != nil {
+			return KeySlot{}, false, err
+		}
+
+		oldKey, ok, err := slot.unwrap(secret)
+		if err != nil || !ok {
+			return KeySlot{}, false, err
+		}
+		wipeBytes(oldKey)
+
+		rewrapped, err := newKeySlot(SlotPassword, slot.Label, secret, slot.KeyFile, slot.KDF, newKey, cipherName)
+		rewrapped.ID = slot.ID
+		return rewrapped, err == nil, err
+	}, nil)
+}
+
+// Wraps a new data key for the member of slot, keeping the slot id
+func rewrapMember(slot KeySlot, newKey []byte) (KeySlot, bool, error) {
+	rewrapped, err := newMemberSlot(slot.Label, slot.Recipient, newKey)
+	if err != nil {
+		return KeySlot{}, false, err
+	}
+	rewrapped.ID = slot.ID
+	return rewrapped, true, nil
+}
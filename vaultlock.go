@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Returned by AcquireVaultLock when another instance has the vault open for writing
+var ErrVaultLocked = errors.New("vault is open in another instance")
+
+// Returned by saves when the file on disk is not the one that was last loaded
+var ErrVaultChanged = errors.New("vault was changed by another program since it was loaded, reopen it to see the changes")
+
+// Advisory lock on a vault, held while it is open for writing. It is taken on a sidecar
+// file because saves replace the vault file itself
+type VaultLock struct {
+	file *os.File
+}
+
+func lockFilePath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".lock")
+}
+
+// Locks the vault at path without waiting, ErrVaultLocked when another process holds it
+func AcquireVaultLock(path string) (*VaultLock, error) {
+	file, err := os.OpenFile(lockFilePath(path), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &VaultLock{file: file}, nil
+}
+
+// The lock file stays, removing it could let two processes lock different inodes
+func (l *VaultLock) Release() {
+	if l == nil || l.file == nil {
+		return
+	}
+	_ = unlockFile(l.file)
+	_ = l.file.Close()
+	l.file = nil
+}
+
+// What a vault looked like on disk when it was loaded
+type fileStamp struct {
+	Revision uint64
+	ModTime  time.Time
+	Size     int64
+}
+
+// Stamps of vaults as last loaded by ReadPasswordFile or written by saveVault, by path
+var loadedStamps = struct {
+	sync.Mutex
+	stamps map[string]fileStamp
+}{stamps: make(map[string]fileStamp)}
+
+func statVault(path string, revision uint64) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{Revision: revision, ModTime: info.ModTime(), Size: info.Size()}, nil
+}
+
+func rememberStamp(path string, stamp fileStamp) {
+	loadedStamps.Lock()
+	defer loadedStamps.Unlock()
+	loadedStamps.stamps[path] = stamp
+}
+
+// Drops the stamp of a closed vault, changes made while it was closed are not conflicts
+func forgetStamp(path string) {
+	loadedStamps.Lock()
+	defer loadedStamps.Unlock()
+	delete(loadedStamps.stamps, path)
+}
+
+// Refuses to save over a vault that changed on disk since it was loaded.
+// Vaults never loaded by ReadPasswordFile, e.g. during creation or migration, are not checked
+func checkStamp(path string) error {
+	loadedStamps.Lock()
+	loaded, ok := loadedStamps.stamps[path]
+	loadedStamps.Unlock()
+	if !ok {
+		return nil
+	}
+
+	header, err := ReadPasswordFileHeader(path)
+	if err != nil {
+		return err
+	}
+
+	current, err := statVault(path, header.Revision)
+	if err != nil {
+		return err
+	}
+	if current.Revision != loaded.Revision || !current.ModTime.Equal(loaded.ModTime) || current.Size != loaded.Size {
+		return ErrVaultChanged
+	}
+	return nil
+}
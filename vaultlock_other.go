@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// No advisory locking here, the stamp check before saves still catches concurrent writers
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func waitLockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
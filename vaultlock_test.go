@@ -0,0 +1,118 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAcquireVaultLock(t *testing.T) {
+	path, _ := newTestVault(t)
+
+	lock, err := AcquireVaultLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flock locks belong to the open file, so a second open in this process contends like another instance
+	if second, err := AcquireVaultLock(path); !errors.Is(err, ErrVaultLocked) {
+		second.Release()
+		t.Fatalf("second lock: %v, want ErrVaultLocked", err)
+	}
+
+	lock.Release()
+	// Releasing twice is harmless
+	lock.Release()
+
+	again, err := AcquireVaultLock(path)
+	if err != nil {
+		t.Fatalf("lock after release: %v", err)
+	}
+	again.Release()
+}
+
+func TestCheckStamp(t *testing.T) {
+	tests := []struct {
+		name string
+		// Changes the vault file after it was loaded, nil leaves it alone.
+		// old is the file as it was before the last save
+		change func(t *testing.T, path string, key, old []byte)
+		want   error
+	}{
+		{"unchanged", nil, nil},
+		{"saved by another instance", func(t *testing.T, path string, key, _ []byte) {
+			// Saves through a copy, as another instance would, with its own stamp
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			other := path + ".other"
+			if err := os.WriteFile(other, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := AddToPasswordFile(other, Entry{Title: "Mail"}, key); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(other, path); err != nil {
+				t.Fatal(err)
+			}
+		}, ErrVaultChanged},
+		// Closing the vault forgets the stamp, a change made while closed is no conflict
+		{"changed while closed", func(t *testing.T, path string, _, _ []byte) {
+			forgetStamp(path)
+			later := time.Now().Add(time.Minute)
+			if err := os.Chtimes(path, later, later); err != nil {
+				t.Fatal(err)
+			}
+		}, nil},
+		{"same content touched", func(t *testing.T, path string, _, _ []byte) {
+			later := time.Now().Add(time.Minute)
+			if err := os.Chtimes(path, later, later); err != nil {
+				t.Fatal(err)
+			}
+		}, ErrVaultChanged},
+		{"replaced with an older copy", func(t *testing.T, path string, _, old []byte) {
+			if err := os.WriteFile(path, old, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}, ErrVaultChanged},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, key := newTestVault(t)
+			old, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := AddToPasswordFile(path, Entry{Title: "Forum"}, key); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ReadPasswordFile(path, key); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.change != nil {
+				tt.change(t, path, key, old)
+			}
+
+			if err := checkStamp(path); !errors.Is(err, tt.want) {
+				t.Fatalf("check: %v, want %v", err, tt.want)
+			}
+			err = AddToPasswordFile(path, Entry{Title: "Bank"}, key)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("save: %v, want %v", err, tt.want)
+			}
+
+			// The vault's own save is the new baseline
+			if tt.want == nil {
+				if err := AddToPasswordFile(path, Entry{Title: "Shop"}, key); err != nil {
+					t.Fatalf("second save: %v", err)
+				}
+			}
+		})
+	}
+}